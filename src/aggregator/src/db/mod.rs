@@ -4,8 +4,28 @@ mod macros;
 database! {
     schema {
         trades => "trades",
+        trades_details => "trades_details",
+        trades_instrument => "trades_instrument",
+        trades_trade_id => "trades_trade_id",
+        trades_trade_key => "trades_trade_key",
     }
     query {
         insert_trade => "insert_trade",
+        insert_trade_batch => "insert_trade_batch",
+    }
+}
+
+#[cfg(test)]
+impl Database {
+    // Number of trades stored for the exchange
+    pub async fn count_trades(&self, exchange: &str) -> Result<i64, Box<dyn std::error::Error>> {
+        let row = self
+            .client
+            .query_one(
+                "SELECT COUNT(*) FROM trades WHERE exchange = $1",
+                &[&exchange],
+            )
+            .await?;
+        Ok(row.get(0))
     }
 }
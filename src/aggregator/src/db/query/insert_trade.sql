@@ -3,11 +3,32 @@ INSERT INTO trades (
     "price",
     "quantity",
     "trade_time",
-    "exchange"
+    "exchange",
+    "trade_id",
+    "first_trade_id",
+    "last_trade_id",
+    "side",
+    "order_type",
+    "event_time",
+    "instrument",
+    "base_asset",
+    "quote_asset",
+    "trade_key"
 ) VALUES (
     $1,
     $2,
     $3,
     TO_TIMESTAMP($4),
-    $5
-);
+    $5,
+    NULLIF($6::TEXT, ''),
+    NULLIF($7::BIGINT, 0),
+    NULLIF($8::BIGINT, 0),
+    $9::TEXT,
+    $10::TEXT,
+    TO_TIMESTAMP(NULLIF($11::DOUBLE PRECISION, 0)),
+    NULLIF($12::TEXT, ''),
+    NULLIF($13::TEXT, ''),
+    NULLIF($14::TEXT, ''),
+    NULLIF($15::TEXT, '')
+)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,66 @@
+INSERT INTO trades (
+    "symbol",
+    "price",
+    "quantity",
+    "trade_time",
+    "exchange",
+    "trade_id",
+    "first_trade_id",
+    "last_trade_id",
+    "side",
+    "order_type",
+    "event_time",
+    "instrument",
+    "base_asset",
+    "quote_asset",
+    "trade_key"
+) SELECT
+    t.symbol,
+    t.price,
+    t.quantity,
+    TO_TIMESTAMP(t.trade_time),
+    t.exchange,
+    NULLIF(t.trade_id, ''),
+    NULLIF(t.first_trade_id, 0),
+    NULLIF(t.last_trade_id, 0),
+    t.side,
+    t.order_type,
+    TO_TIMESTAMP(NULLIF(t.event_time, 0)),
+    NULLIF(t.instrument, ''),
+    NULLIF(t.base_asset, ''),
+    NULLIF(t.quote_asset, ''),
+    NULLIF(t.trade_key, '')
+FROM UNNEST(
+    $1::TEXT[],
+    $2::TEXT[],
+    $3::TEXT[],
+    $4::DOUBLE PRECISION[],
+    $5::TEXT[],
+    $6::TEXT[],
+    $7::BIGINT[],
+    $8::BIGINT[],
+    $9::TEXT[],
+    $10::TEXT[],
+    $11::DOUBLE PRECISION[],
+    $12::TEXT[],
+    $13::TEXT[],
+    $14::TEXT[],
+    $15::TEXT[]
+) AS t (
+    symbol,
+    price,
+    quantity,
+    trade_time,
+    exchange,
+    trade_id,
+    first_trade_id,
+    last_trade_id,
+    side,
+    order_type,
+    event_time,
+    instrument,
+    base_asset,
+    quote_asset,
+    trade_key
+)
+ON CONFLICT DO NOTHING;
@@ -1,7 +1,17 @@
 CREATE UNLOGGED TABLE IF NOT EXISTS trades (
-    "symbol"     TEXT NOT NULL,
-    "price"      TEXT NOT NULL,
-    "quantity"   TEXT NOT NULL,
-    "trade_time" TIMESTAMP NOT NULL,
-    "exchange"   TEXT NOT NULL
+    "symbol"         TEXT NOT NULL,
+    "price"          TEXT NOT NULL,
+    "quantity"       TEXT NOT NULL,
+    "trade_time"     TIMESTAMP NOT NULL,
+    "exchange"       TEXT NOT NULL,
+    "trade_id"       TEXT,
+    "first_trade_id" BIGINT,
+    "last_trade_id"  BIGINT,
+    "side"           TEXT,
+    "order_type"     TEXT,
+    "event_time"     TIMESTAMP,
+    "instrument"     TEXT,
+    "base_asset"     TEXT,
+    "quote_asset"    TEXT,
+    "trade_key"      TEXT
 );
@@ -0,0 +1,7 @@
+ALTER TABLE trades
+    ADD COLUMN IF NOT EXISTS "trade_id"       TEXT,
+    ADD COLUMN IF NOT EXISTS "first_trade_id" BIGINT,
+    ADD COLUMN IF NOT EXISTS "last_trade_id"  BIGINT,
+    ADD COLUMN IF NOT EXISTS "side"           TEXT,
+    ADD COLUMN IF NOT EXISTS "order_type"     TEXT,
+    ADD COLUMN IF NOT EXISTS "event_time"     TIMESTAMP;
@@ -0,0 +1,4 @@
+ALTER TABLE trades
+    ADD COLUMN IF NOT EXISTS "instrument"  TEXT,
+    ADD COLUMN IF NOT EXISTS "base_asset"  TEXT,
+    ADD COLUMN IF NOT EXISTS "quote_asset" TEXT;
@@ -0,0 +1,15 @@
+DO $$
+BEGIN
+    -- Replayed batches must not store a trade twice, duplicates stored before the index are dropped once
+    IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = 'trades' AND indexname = 'trades_trade_id_key') THEN
+        DELETE FROM trades a
+            USING trades b
+            WHERE a.ctid < b.ctid
+              AND a.exchange = b.exchange
+              AND a.symbol = b.symbol
+              AND a.trade_id = b.trade_id;
+
+        CREATE UNIQUE INDEX trades_trade_id_key ON trades ("exchange", "symbol", "trade_id");
+    END IF;
+END
+$$;
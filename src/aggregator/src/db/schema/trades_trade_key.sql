@@ -0,0 +1,4 @@
+ALTER TABLE trades
+    ADD COLUMN IF NOT EXISTS "trade_key" TEXT;
+-- Trades without an exchange trade id, like Kraken's, are only deduplicated by their idempotency key
+CREATE UNIQUE INDEX IF NOT EXISTS trades_trade_key_key ON trades ("exchange", "symbol", "trade_key");
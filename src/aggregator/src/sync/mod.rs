@@ -43,15 +43,14 @@ impl SyncService {
 }
 
 type TradeRequest = Request<pb::TradeRequest>;
+type TradeBatchRequest = Request<pb::TradeBatch>;
 
-#[async_trait]
-impl pb::sync_service_server::SyncService for SyncService {
-    #[tracing::instrument(skip(self))]
-    async fn push_trade(&self, request: TradeRequest) -> Result<Response<pb::Empty>, Status> {
-        tracing::trace!("Saving new trade");
+impl SyncService {
+    async fn save_trade(&self, trade: &pb::TradeRequest) -> Result<(), Status> {
         self.incoming_trades_total.inc();
         let timer = self.incoming_trades_performance.start_timer();
-        let trade = request.into_inner();
+        let side = side_name(trade.side);
+        let order_type = order_type_name(trade.order_type);
         let result = self
             .db
             .insert_trade(&[
@@ -60,6 +59,16 @@ impl pb::sync_service_server::SyncService for SyncService {
                 &trade.quantity,
                 &trade.trade_time,
                 &trade.exchange,
+                &trade.trade_id,
+                &trade.first_trade_id,
+                &trade.last_trade_id,
+                &side,
+                &order_type,
+                &trade.event_time,
+                &trade.instrument,
+                &trade.base_asset,
+                &trade.quote_asset,
+                &trade.trade_key,
             ])
             .await;
 
@@ -67,7 +76,7 @@ impl pb::sync_service_server::SyncService for SyncService {
             Ok(_) => {
                 timer.observe_duration();
                 self.incoming_trades_success.inc();
-                Ok(Response::new(pb::Empty {}))
+                Ok(())
             }
             Err(e) => {
                 timer.observe_duration();
@@ -77,4 +86,158 @@ impl pb::sync_service_server::SyncService for SyncService {
             }
         }
     }
+
+    // Saves the whole batch in a single statement, a failed batch stores none of its trades
+    // and a replayed one skips the trades already stored by their trade id or idempotency key
+    async fn save_trades(&self, trades: &[pb::TradeRequest]) -> Result<(), Status> {
+        if trades.is_empty() {
+            return Ok(());
+        }
+
+        let count = trades.len() as f64;
+        self.incoming_trades_total.inc_by(count);
+        let timer = self.incoming_trades_performance.start_timer();
+
+        let symbols: Vec<&str> = trades.iter().map(|t| t.symbol.as_str()).collect();
+        let prices: Vec<&str> = trades.iter().map(|t| t.price.as_str()).collect();
+        let quantities: Vec<&str> = trades.iter().map(|t| t.quantity.as_str()).collect();
+        let trade_times: Vec<f64> = trades.iter().map(|t| t.trade_time).collect();
+        let exchanges: Vec<&str> = trades.iter().map(|t| t.exchange.as_str()).collect();
+        let trade_ids: Vec<&str> = trades.iter().map(|t| t.trade_id.as_str()).collect();
+        let first_trade_ids: Vec<i64> = trades.iter().map(|t| t.first_trade_id).collect();
+        let last_trade_ids: Vec<i64> = trades.iter().map(|t| t.last_trade_id).collect();
+        let sides: Vec<Option<&str>> = trades.iter().map(|t| side_name(t.side)).collect();
+        let order_types: Vec<Option<&str>> = trades
+            .iter()
+            .map(|t| order_type_name(t.order_type))
+            .collect();
+        let event_times: Vec<f64> = trades.iter().map(|t| t.event_time).collect();
+        let instruments: Vec<&str> = trades.iter().map(|t| t.instrument.as_str()).collect();
+        let base_assets: Vec<&str> = trades.iter().map(|t| t.base_asset.as_str()).collect();
+        let quote_assets: Vec<&str> = trades.iter().map(|t| t.quote_asset.as_str()).collect();
+        let trade_keys: Vec<&str> = trades.iter().map(|t| t.trade_key.as_str()).collect();
+
+        let result = self
+            .db
+            .insert_trade_batch(&[
+                &symbols,
+                &prices,
+                &quantities,
+                &trade_times,
+                &exchanges,
+                &trade_ids,
+                &first_trade_ids,
+                &last_trade_ids,
+                &sides,
+                &order_types,
+                &event_times,
+                &instruments,
+                &base_assets,
+                &quote_assets,
+                &trade_keys,
+            ])
+            .await;
+
+        match result {
+            Ok(_) => {
+                timer.observe_duration();
+                self.incoming_trades_success.inc_by(count);
+                Ok(())
+            }
+            Err(e) => {
+                timer.observe_duration();
+                self.incoming_trades_failed.inc_by(count);
+                tracing::error!("Unable to save {} trades {}", trades.len(), e);
+                Err(Status::internal(e.to_string()))
+            }
+        }
+    }
+}
+
+fn side_name(side: i32) -> Option<&'static str> {
+    match pb::Side::from_i32(side) {
+        Some(pb::Side::Buy) => Some("buy"),
+        Some(pb::Side::Sell) => Some("sell"),
+        _ => None,
+    }
+}
+
+fn order_type_name(order_type: i32) -> Option<&'static str> {
+    match pb::OrderType::from_i32(order_type) {
+        Some(pb::OrderType::Market) => Some("market"),
+        Some(pb::OrderType::Limit) => Some("limit"),
+        _ => None,
+    }
+}
+
+#[async_trait]
+impl pb::sync_service_server::SyncService for SyncService {
+    #[tracing::instrument(skip(self))]
+    async fn push_trade(&self, request: TradeRequest) -> Result<Response<pb::Empty>, Status> {
+        tracing::trace!("Saving new trade");
+        let trade = request.into_inner();
+        self.save_trade(&trade).await?;
+        Ok(Response::new(pb::Empty {}))
+    }
+
+    #[tracing::instrument(skip(self, request))]
+    async fn push_trade_batch(
+        &self,
+        request: TradeBatchRequest,
+    ) -> Result<Response<pb::Empty>, Status> {
+        let batch = request.into_inner();
+        tracing::trace!("Saving {} new trades", batch.trades.len());
+        self.save_trades(&batch.trades).await?;
+        Ok(Response::new(pb::Empty {}))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::{SystemTime, UNIX_EPOCH};
+
+    fn kraken_trade(exchange: &str, price: &str, trade_key: &str) -> pb::TradeRequest {
+        pb::TradeRequest {
+            symbol: "XBT/USD".to_string(),
+            price: price.to_string(),
+            quantity: "1.0".to_string(),
+            trade_time: 1600000000.5,
+            exchange: exchange.to_string(),
+            side: pb::Side::Buy as i32,
+            trade_key: trade_key.to_string(),
+            ..Default::default()
+        }
+    }
+
+    // Needs a database, skipped unless DATABASE_URL is set
+    #[tokio::test]
+    async fn replayed_kraken_batch_is_stored_once() {
+        dotenv::dotenv().ok();
+        if std::env::var("DATABASE_URL").is_err() {
+            eprintln!("DATABASE_URL is not set, skipping");
+            return;
+        }
+
+        let service = SyncService::new(Database::connect().await.unwrap());
+
+        // Unique per run so the trades stored by earlier runs are not counted
+        let nanos = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .unwrap()
+            .as_nanos();
+        let exchange = format!("kraken-test-{}", nanos);
+
+        // Kraken trades carry no trade id, identical fills differ only in their key
+        let batch = vec![
+            kraken_trade(&exchange, "100.0", "1600000000.500000/100.0/1.0/1"),
+            kraken_trade(&exchange, "100.0", "1600000000.500000/100.0/1.0/2"),
+            kraken_trade(&exchange, "101.0", "1600000000.500000/101.0/1.0/1"),
+        ];
+
+        service.save_trades(&batch).await.unwrap();
+        service.save_trades(&batch).await.unwrap();
+
+        assert_eq!(service.db.count_trades(&exchange).await.unwrap(), 3);
+    }
 }
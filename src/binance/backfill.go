@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const BACKFILL_PAGE_LIMIT = 1000
+const BACKFILL_MAX_PAGES = 10
+
+var (
+	binance_backfill_trades_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_backfill_trades_total",
+		Help: "The total number of trades recovered from the REST api after reconnects",
+	})
+	binance_backfill_errors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_backfill_errors",
+		Help: "The total number of failed trade backfill requests",
+	})
+)
+
+// backfillBinanceTrades emits the trades missed since the last seen aggregated trade id of every symbol
+func backfillBinanceTrades(ctx context.Context, api string, lastTradeIDs map[string]int64, events chan StreamEvent) {
+	for symbol, lastID := range lastTradeIDs {
+		for page := 0; page < BACKFILL_MAX_PAGES; page++ {
+			trades, err := fetchAggTrades(ctx, api, symbol, lastID+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				binance_backfill_errors.Inc()
+				log.Printf("Backfill %v trades: %v", symbol, err)
+				break
+			}
+
+			for _, trade := range trades {
+				trade.EventType = "aggTrade"
+				trade.Symbol = symbol
+				lastID = trade.AggTradeID
+
+				events <- StreamEvent{
+					Stream:  strings.ToLower(symbol) + "@aggTrade",
+					Payload: trade,
+				}
+			}
+
+			binance_backfill_trades_total.Add(float64(len(trades)))
+			lastTradeIDs[symbol] = lastID
+
+			if len(trades) < BACKFILL_PAGE_LIMIT {
+				break
+			}
+		}
+	}
+}
+
+func fetchAggTrades(ctx context.Context, api string, symbol string, fromID int64) ([]AggregatedTrade, error) {
+	url := fmt.Sprintf("%v/api/v3/aggTrades?symbol=%v&fromId=%v&limit=%v", api, symbol, fromID, BACKFILL_PAGE_LIMIT)
+
+	resp, err := binanceGet(ctx, url, WEIGHT_AGG_TRADES)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	var trades []AggregatedTrade
+	err = json.NewDecoder(resp.Body).Decode(&trades)
+
+	return trades, err
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"net/http"
+
+	"collector"
+)
+
+// Shared by all connections to Binance, which answers 429 once the limits are exceeded
+// and 418 once the IP got banned for ignoring them
+var exchangeCircuit = collector.NewCircuit(http.StatusTooManyRequests, http.StatusTeapot)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"collector"
+)
+
+// Sink recording the pushed trades
+type recordingSink struct {
+	trades []*collector.TradeRequest
+}
+
+func (s *recordingSink) Push(trade *collector.TradeRequest) {
+	s.trades = append(s.trades, trade)
+}
+
+func (s *recordingSink) Close() {}
+
+// Writes the frames to a new capture file and returns its path
+func writeCapture(t *testing.T, frames ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "frames.capture")
+
+	capture, err := collector.CreateCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := time.Now()
+	for i, frame := range frames {
+		capture.Write(1, received.Add(time.Duration(i)*time.Millisecond), []byte(frame))
+	}
+
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReplayCapturedFrames(t *testing.T) {
+	exchange := newFakeBinance(t, fakeSymbol("BTC", "USDT"))
+	*binanceApi = exchange.URL
+
+	path := writeCapture(t,
+		`{"stream":"btcusdt@aggTrade","data":{"e":"aggTrade","E":1600000000100,"s":"BTCUSDT","a":1,"p":"10000.00","q":"0.5","f":1,"l":2,"T":1600000000000,"m":false}}`,
+		`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","E":1600000000150,"s":"BTCUSDT","U":1,"u":2,"b":[["9999.00","1"]],"a":[]}}`,
+		`not a frame`,
+		`{"stream":"btcusdt@aggTrade","data":{"e":"aggTrade","E":1600000000200,"s":"BTCUSDT","a":2,"p":"10001.00","q":"0.25","f":3,"l":3,"T":1600000000190,"m":true}}`,
+		// Sent again by a reconnected stream
+		`{"stream":"btcusdt@aggTrade","data":{"e":"aggTrade","E":1600000000200,"s":"BTCUSDT","a":2,"p":"10001.00","q":"0.25","f":3,"l":3,"T":1600000000190,"m":true}}`,
+	)
+
+	oldSpeed := *collector.ReplaySpeed
+	t.Cleanup(func() { *collector.ReplaySpeed = oldSpeed })
+	*collector.ReplaySpeed = 0
+
+	sink := &recordingSink{}
+	replay(path, sink)
+
+	if len(sink.trades) != 2 {
+		t.Fatalf("got %v trades, want 2", len(sink.trades))
+	}
+
+	buy, sell := sink.trades[0], sink.trades[1]
+	if buy.Price != "10000.00" || buy.Quantity != "0.5" || buy.TradeTime != 1600000000 || buy.Side != collector.Side_SIDE_BUY {
+		t.Errorf("unexpected trade: %v", buy)
+	}
+	if buy.Instrument != "BTC-USDT" || buy.BaseAsset != "BTC" || buy.QuoteAsset != "USDT" {
+		t.Errorf("instrument not resolved: %v", buy)
+	}
+	if sell.Price != "10001.00" || sell.Side != collector.Side_SIDE_SELL || sell.TradeId != "2" {
+		t.Errorf("unexpected trade: %v", sell)
+	}
+}
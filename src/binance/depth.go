@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const DEPTH_STREAM_SUFFIX = "@depth@100ms"
+const DEPTH_SNAPSHOT_LIMIT = 1000
+
+// Updates buffered per symbol while waiting for a snapshot, 100s of the 100ms stream
+const DEPTH_BUFFER_MAX = 1000
+
+var depthSymbols = flag.String("depth", "", "comma separated list of symbols to maintain order books for")
+var depthLevels = flag.Int("depth-levels", 20, "number of top order book levels exposed")
+
+var (
+	binance_orderbook_synced = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "binance_orderbook_synced",
+		Help: "The number of order books in sync with Binance",
+	})
+	binance_orderbook_resyncs_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_orderbook_resyncs_total",
+		Help: "The total number of order book resyncs caused by sequence gaps",
+	})
+	binance_orderbook_snapshot_errors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_orderbook_snapshot_errors",
+		Help: "The total number of failed order book snapshot requests",
+	})
+	binance_orderbook_updates_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_orderbook_updates_total",
+		Help: "The total number of depth updates applied to order books",
+	})
+)
+
+// Result of a single depth snapshot request
+type depthSnapshotResult struct {
+	symbol   string
+	snapshot DepthSnapshot
+	err      error
+}
+
+// Synchronization state of a single symbol
+type depthSync struct {
+	book    *OrderBook
+	buffer  []DepthUpdate
+	pending bool
+}
+
+// Maintains local order books from Binance depth streams
+type OrderBookManager struct {
+	api       string
+	levels    int
+	mu        sync.RWMutex
+	books     map[string]*OrderBook
+	state     map[string]*depthSync
+	requests  chan string
+	snapshots chan depthSnapshotResult
+}
+
+// ParseDepthSymbols returns the symbols configured with the depth flag
+func ParseDepthSymbols(value string) []string {
+	symbols := make([]string, 0)
+
+	for _, symbol := range strings.Split(value, ",") {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol != "" {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	return symbols
+}
+
+// NewOrderBookManager creates order book manager which fetches snapshots from the given api
+func NewOrderBookManager(api string, levels int) *OrderBookManager {
+	m := &OrderBookManager{
+		api:       api,
+		levels:    levels,
+		books:     make(map[string]*OrderBook),
+		state:     make(map[string]*depthSync),
+		requests:  make(chan string),
+		snapshots: make(chan depthSnapshotResult),
+	}
+
+	go m.fetchSnapshots()
+
+	return m
+}
+
+// Book returns the order book of the symbol if it is in sync
+func (m *OrderBookManager) Book(symbol string) (*OrderBook, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	book, ok := m.books[symbol]
+	return book, ok
+}
+
+// Run applies depth events to the order books until the events channel is closed
+func (m *OrderBookManager) Run(events chan DepthEvent) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			m.handleUpdate(event.Payload)
+		case result := <-m.snapshots:
+			m.handleSnapshot(result)
+		}
+	}
+}
+
+// ServeHTTP returns the top levels of the requested symbol order book
+func (m *OrderBookManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.ToUpper(r.URL.Query().Get("symbol"))
+	levels := m.levels
+
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 && limit < levels {
+		levels = limit
+	}
+
+	book, ok := m.Book(symbol)
+	if !ok {
+		http.Error(w, "order book not available", http.StatusNotFound)
+		return
+	}
+
+	bids, asks := book.Top(levels)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Symbol       string       `json:"symbol"`
+		LastUpdateID int64        `json:"lastUpdateId"`
+		Bids         []PriceLevel `json:"bids"`
+		Asks         []PriceLevel `json:"asks"`
+	}{
+		Symbol:       symbol,
+		LastUpdateID: book.LastUpdateID(),
+		Bids:         bids,
+		Asks:         asks,
+	})
+}
+
+func (m *OrderBookManager) handleUpdate(update DepthUpdate) {
+	state, ok := m.state[update.Symbol]
+	if !ok {
+		state = &depthSync{}
+		m.state[update.Symbol] = state
+	}
+
+	if state.book == nil {
+		// Buffer the updates until the snapshot arrives
+		state.bufferUpdate(update)
+		m.requestSnapshot(update.Symbol, state)
+		return
+	}
+
+	if _, err := state.book.ApplyDiff(update); err != nil {
+		log.Printf("Order book %v out of sync: %v", update.Symbol, err)
+		binance_orderbook_resyncs_total.Inc()
+		m.resync(update.Symbol, state)
+		state.bufferUpdate(update)
+		m.requestSnapshot(update.Symbol, state)
+		return
+	}
+
+	binance_orderbook_updates_total.Inc()
+}
+
+func (m *OrderBookManager) handleSnapshot(result depthSnapshotResult) {
+	state := m.state[result.symbol]
+	state.pending = false
+
+	if result.err != nil {
+		binance_orderbook_snapshot_errors.Inc()
+		log.Printf("Order book %v snapshot: %v", result.symbol, result.err)
+		m.requestSnapshot(result.symbol, state)
+		return
+	}
+
+	book := NewOrderBook(result.symbol, result.snapshot)
+
+	// The updates covered by the snapshot are never needed again, even when a newer snapshot is requested
+	state.trimBuffer(result.snapshot.LastUpdateID)
+
+	for _, update := range state.buffer {
+		if _, err := book.ApplyDiff(update); err != nil {
+			// The snapshot is older than the buffered updates, need a new one
+			m.requestSnapshot(result.symbol, state)
+			return
+		}
+	}
+
+	state.book = book
+	state.buffer = nil
+	binance_orderbook_synced.Inc()
+
+	m.mu.Lock()
+	m.books[result.symbol] = book
+	m.mu.Unlock()
+}
+
+// Buffers the update for the next snapshot, the oldest updates are dropped once the buffer is full
+// as the snapshot will be newer than them anyway
+func (s *depthSync) bufferUpdate(update DepthUpdate) {
+	if len(s.buffer) >= DEPTH_BUFFER_MAX {
+		s.buffer = append(s.buffer[:0], s.buffer[len(s.buffer)-DEPTH_BUFFER_MAX+1:]...)
+	}
+	s.buffer = append(s.buffer, update)
+}
+
+// Drops the buffered updates up to the last update id of the snapshot
+func (s *depthSync) trimBuffer(lastUpdateID int64) {
+	buffer := s.buffer[:0]
+	for _, update := range s.buffer {
+		if update.FinalUpdateID > lastUpdateID {
+			buffer = append(buffer, update)
+		}
+	}
+	s.buffer = buffer
+}
+
+func (m *OrderBookManager) resync(symbol string, state *depthSync) {
+	state.book = nil
+	state.buffer = nil
+	binance_orderbook_synced.Dec()
+
+	m.mu.Lock()
+	delete(m.books, symbol)
+	m.mu.Unlock()
+}
+
+func (m *OrderBookManager) requestSnapshot(symbol string, state *depthSync) {
+	if state.pending {
+		return
+	}
+
+	state.pending = true
+
+	go func() {
+		m.requests <- symbol
+	}()
+}
+
+func (m *OrderBookManager) fetchSnapshots() {
+	for symbol := range m.requests {
+		snapshot, err := fetchDepthSnapshot(m.api, symbol)
+		m.snapshots <- depthSnapshotResult{
+			symbol:   symbol,
+			snapshot: snapshot,
+			err:      err,
+		}
+	}
+}
+
+func fetchDepthSnapshot(api string, symbol string) (DepthSnapshot, error) {
+	url := fmt.Sprintf("%v/api/v3/depth?symbol=%v&limit=%v", api, symbol, DEPTH_SNAPSHOT_LIMIT)
+
+	var snapshot DepthSnapshot
+	resp, err := binanceGet(context.Background(), url, WEIGHT_DEPTH)
+
+	if err != nil {
+		return snapshot, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return snapshot, errors.New(resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&snapshot)
+
+	return snapshot, err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Creates the manager without the snapshot fetcher, the test answers the requests itself
+func newTestOrderBookManager() *OrderBookManager {
+	return &OrderBookManager{
+		books:    make(map[string]*OrderBook),
+		state:    make(map[string]*depthSync),
+		requests: make(chan string, 1),
+	}
+}
+
+func expectSnapshotRequest(t *testing.T, m *OrderBookManager) {
+	t.Helper()
+
+	select {
+	case <-m.requests:
+	case <-time.After(time.Second):
+		t.Fatal("no snapshot requested")
+	}
+}
+
+func depthUpdate(first int64, final int64) DepthUpdate {
+	return DepthUpdate{Symbol: "BTCUSDT", FirstUpdateID: first, FinalUpdateID: final, Bids: [][2]string{{"10.0", "1"}}}
+}
+
+func TestOrderBookManagerSync(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		buffered []DepthUpdate
+		snapshot depthSnapshotResult
+		synced   bool
+		last     int64
+	}{
+		{
+			name:     "buffered updates overlapping the snapshot",
+			buffered: []DepthUpdate{depthUpdate(90, 95), depthUpdate(96, 103), depthUpdate(104, 110)},
+			snapshot: depthSnapshotResult{snapshot: DepthSnapshot{LastUpdateID: 100}},
+			synced:   true,
+			last:     110,
+		},
+		{
+			name:     "snapshot newer than the buffered updates",
+			buffered: []DepthUpdate{depthUpdate(90, 95)},
+			snapshot: depthSnapshotResult{snapshot: DepthSnapshot{LastUpdateID: 100}},
+			synced:   true,
+			last:     100,
+		},
+		{
+			name:     "snapshot older than the buffered updates",
+			buffered: []DepthUpdate{depthUpdate(105, 110)},
+			snapshot: depthSnapshotResult{snapshot: DepthSnapshot{LastUpdateID: 100}},
+		},
+		{
+			name:     "failed snapshot",
+			buffered: []DepthUpdate{depthUpdate(90, 95)},
+			snapshot: depthSnapshotResult{err: errors.New("503 Service Unavailable")},
+		},
+	} {
+		m := newTestOrderBookManager()
+
+		for _, update := range test.buffered {
+			m.handleUpdate(update)
+		}
+		expectSnapshotRequest(t, m)
+
+		test.snapshot.symbol = "BTCUSDT"
+		m.handleSnapshot(test.snapshot)
+
+		book, ok := m.Book("BTCUSDT")
+		if ok != test.synced {
+			t.Errorf("%v: got synced %v, want %v", test.name, ok, test.synced)
+			continue
+		}
+
+		if !ok {
+			// A new snapshot is requested until the book is in sync
+			expectSnapshotRequest(t, m)
+		} else if book.LastUpdateID() != test.last {
+			t.Errorf("%v: got last update %v, want %v", test.name, book.LastUpdateID(), test.last)
+		}
+	}
+}
+
+func TestOrderBookManagerResyncsOnGap(t *testing.T) {
+	m := newTestOrderBookManager()
+
+	m.handleUpdate(depthUpdate(95, 101))
+	expectSnapshotRequest(t, m)
+	m.handleSnapshot(depthSnapshotResult{symbol: "BTCUSDT", snapshot: DepthSnapshot{LastUpdateID: 100}})
+
+	if _, ok := m.Book("BTCUSDT"); !ok {
+		t.Fatal("book not in sync after the snapshot")
+	}
+
+	// The gap drops the book and buffers the update for the next snapshot
+	m.handleUpdate(depthUpdate(105, 110))
+
+	if _, ok := m.Book("BTCUSDT"); ok {
+		t.Fatal("book still available after a gap")
+	}
+	expectSnapshotRequest(t, m)
+
+	m.handleSnapshot(depthSnapshotResult{symbol: "BTCUSDT", snapshot: DepthSnapshot{LastUpdateID: 107}})
+
+	if book, ok := m.Book("BTCUSDT"); !ok || book.LastUpdateID() != 110 {
+		t.Fatal("book not resynced from the new snapshot")
+	}
+}
+
+func TestOrderBookManagerTrimsBufferOnEverySnapshot(t *testing.T) {
+	m := newTestOrderBookManager()
+
+	m.handleUpdate(depthUpdate(90, 95))
+	m.handleUpdate(depthUpdate(105, 110))
+	expectSnapshotRequest(t, m)
+
+	// Too old to apply the buffer, the updates it covers are dropped nonetheless
+	m.handleSnapshot(depthSnapshotResult{symbol: "BTCUSDT", snapshot: DepthSnapshot{LastUpdateID: 100}})
+	expectSnapshotRequest(t, m)
+
+	if buffer := m.state["BTCUSDT"].buffer; len(buffer) != 1 || buffer[0].FinalUpdateID != 110 {
+		t.Errorf("got buffer %v, want only the update past the snapshot", buffer)
+	}
+}
+
+func TestOrderBookManagerCapsBuffer(t *testing.T) {
+	m := newTestOrderBookManager()
+
+	for i := int64(1); i <= DEPTH_BUFFER_MAX+10; i++ {
+		m.handleUpdate(depthUpdate(i, i))
+	}
+	expectSnapshotRequest(t, m)
+
+	buffer := m.state["BTCUSDT"].buffer
+	if len(buffer) != DEPTH_BUFFER_MAX || buffer[0].FinalUpdateID != 11 || buffer[len(buffer)-1].FinalUpdateID != DEPTH_BUFFER_MAX+10 {
+		t.Errorf("got %v buffered updates from %v, want the newest %v", len(buffer), buffer[0].FinalUpdateID, DEPTH_BUFFER_MAX)
+	}
+}
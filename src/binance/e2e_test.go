@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"collector"
+)
+
+const e2eTimeout = 10 * time.Second
+
+var (
+	e2eOnce    sync.Once
+	e2eService *fakeSyncService
+	e2eSink    collector.TradeSink
+)
+
+// Collector pipeline running against the fake exchange and aggregator
+type e2eHarness struct {
+	exchange *fakeBinance
+	service  *fakeSyncService
+	pool     *BinanceStreamPool
+	cancel   context.CancelFunc
+	pushed   chan struct{}
+}
+
+// Starts the stream pool against a new fake exchange with the given symbols.
+// The gRPC client registers its metrics globally, so a single client and aggregator are shared by all tests.
+func startHarness(t *testing.T, symbols ...Symbol) *e2eHarness {
+	e2eOnce.Do(func() {
+		var addr string
+		e2eService, addr = startFakeSyncService(t)
+		*collector.GrpcAddr = addr
+		*collector.BatchInterval = 50 * time.Millisecond
+		e2eSink = collector.ConnectGRPC("binance")
+	})
+
+	e2eService.Reset()
+
+	exchange := newFakeBinance(t, symbols...)
+	*binanceApi = exchange.URL
+	*streamAddr = exchange.StreamURL()
+
+	info, err := requestExchangeInfo(*binanceApi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := CreateBinanceStreamPool(ctx, info, nil)
+
+	h := &e2eHarness{exchange: exchange, service: e2eService, pool: pool, cancel: cancel, pushed: make(chan struct{})}
+
+	go func() {
+		pushTrades(pool.Events(), NewInstruments(info.Symbols), e2eSink)
+		close(h.pushed)
+	}()
+
+	// The streams end before the fake exchange is closed, a reconnect would end up at the exchange
+	// of the next test as the addresses are global
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-h.pushed:
+		case <-time.After(e2eTimeout):
+			t.Error("timed out waiting for the pool to end")
+		}
+	})
+
+	h.waitFor(t, "connection", func() bool { return exchange.Connections() > 0 })
+
+	return h
+}
+
+// Polls the condition until it holds or the test times out
+func (h *e2eHarness) waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(e2eTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+}
+
+// Waits until the aggregator receives n trades and returns their trade ids in order
+func (h *e2eHarness) waitTrades(t *testing.T, n int) []string {
+	t.Helper()
+
+	h.waitFor(t, "trades", func() bool { return len(h.service.Trades()) >= n })
+
+	ids := make([]string, 0, n)
+	for _, trade := range h.service.Trades() {
+		ids = append(ids, trade.TradeId)
+	}
+
+	return ids
+}
+
+func aggTrade(symbol string, id int64) AggregatedTrade {
+	return AggregatedTrade{
+		Symbol:     symbol,
+		AggTradeID: id,
+		Price:      "100.5",
+		Quantity:   "0.25",
+		TradeTime:  1600000000000 + id,
+		EventTIme:  1600000000000 + id,
+	}
+}
+
+func assertTradeIDs(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	sort.Strings(got)
+	if len(got) != len(want) {
+		t.Fatalf("got trades %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got trades %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPipelinePushesTrades(t *testing.T) {
+	h := startHarness(t, fakeSymbol("BTC", "USDT"), fakeSymbol("ETH", "BTC"))
+
+	buy := aggTrade("BTCUSDT", 1)
+	sell := aggTrade("ETHBTC", 2)
+	sell.BuyerMarketMaker = true
+
+	h.exchange.Trade(buy)
+	h.exchange.Trade(sell)
+
+	h.waitTrades(t, 2)
+
+	trades := h.service.Trades()
+	sort.Slice(trades, func(i, j int) bool { return trades[i].TradeId < trades[j].TradeId })
+
+	if trades[0].Instrument != "BTC-USDT" || trades[0].Side != collector.Side_SIDE_BUY || trades[0].Exchange != "binance" {
+		t.Errorf("unexpected trade: %v", trades[0])
+	}
+	if trades[1].Instrument != "ETH-BTC" || trades[1].Side != collector.Side_SIDE_SELL || trades[1].TradeTime != 1600000000.002 {
+		t.Errorf("unexpected trade: %v", trades[1])
+	}
+}
+
+func TestPipelineRecoversDroppedConnection(t *testing.T) {
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	h.exchange.Trade(aggTrade("BTCUSDT", 1))
+	h.waitTrades(t, 1)
+
+	h.exchange.Drop()
+
+	// Sent around the reconnect, delivered either by the stream or by the backfill
+	h.exchange.Trade(aggTrade("BTCUSDT", 2))
+	h.waitFor(t, "reconnect", func() bool { return h.exchange.Connections() == 2 })
+	h.exchange.Trade(aggTrade("BTCUSDT", 3))
+
+	h.waitTrades(t, 3)
+
+	// Give possible duplicates a chance to arrive
+	<-time.After(200 * time.Millisecond)
+
+	assertTradeIDs(t, h.waitTrades(t, 3), "1", "2", "3")
+}
+
+func TestPipelineSkipsMalformedFrames(t *testing.T) {
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	h.exchange.Trade(aggTrade("BTCUSDT", 1))
+	h.exchange.SendRaw([]byte(`{"stream":"btcusdt@aggTrade","data":`))
+	h.exchange.SendRaw([]byte(`{"stream":"btcusdt@aggTrade","data":{"a":"not a number"}}`))
+	h.exchange.Trade(aggTrade("BTCUSDT", 2))
+
+	assertTradeIDs(t, h.waitTrades(t, 2), "1", "2")
+
+	if n := h.exchange.Connections(); n != 1 {
+		t.Errorf("malformed frames caused %v connections", n)
+	}
+}
+
+func TestPipelineSurvivesStall(t *testing.T) {
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	h.exchange.Stall(300 * time.Millisecond)
+	h.exchange.Trade(aggTrade("BTCUSDT", 1))
+
+	<-time.After(100 * time.Millisecond)
+	if trades := h.service.Trades(); len(trades) != 0 {
+		t.Fatalf("got trades during stall: %v", trades)
+	}
+
+	assertTradeIDs(t, h.waitTrades(t, 1), "1")
+}
+
+func TestPipelineReconnectsSilentConnection(t *testing.T) {
+	oldTimeout, oldInterval := *collector.ReadTimeout, *collector.PingInterval
+	t.Cleanup(func() { *collector.ReadTimeout, *collector.PingInterval = oldTimeout, oldInterval })
+	*collector.ReadTimeout, *collector.PingInterval = 300*time.Millisecond, 50*time.Millisecond
+
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	// The pongs keep the idle connection alive
+	<-time.After(600 * time.Millisecond)
+
+	if n := h.exchange.Connections(); n != 1 {
+		t.Fatalf("idle connection caused %v connections", n)
+	}
+
+	h.exchange.Trade(aggTrade("BTCUSDT", 1))
+	h.waitTrades(t, 1)
+
+	// Half-open connection, nothing arrives anymore
+	h.exchange.Silence(time.Second)
+	h.exchange.Trade(aggTrade("BTCUSDT", 2))
+
+	h.waitFor(t, "reconnect", func() bool { return h.exchange.Connections() >= 2 })
+
+	// Recovered by the backfill of the new connection
+	assertTradeIDs(t, h.waitTrades(t, 2), "1", "2")
+}
+
+func TestPipelineRotatesWithoutGaps(t *testing.T) {
+	oldAfter, oldOverlap := *rotateAfter, *rotateOverlap
+	t.Cleanup(func() { *rotateAfter, *rotateOverlap = oldAfter, oldOverlap })
+	*rotateAfter, *rotateOverlap = 300*time.Millisecond, 100*time.Millisecond
+
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	// Trades keep coming while the connections are replaced, the ones sent during
+	// the overlap reach both connections
+	want := make([]string, 0)
+	for id := int64(1); id <= 100; id++ {
+		h.exchange.Trade(aggTrade("BTCUSDT", id))
+		want = append(want, strconv.FormatInt(id, 10))
+		<-time.After(10 * time.Millisecond)
+	}
+	sort.Strings(want)
+
+	h.waitTrades(t, 100)
+
+	// Give possible duplicates a chance to arrive
+	<-time.After(200 * time.Millisecond)
+
+	assertTradeIDs(t, h.waitTrades(t, 100), want...)
+
+	if n := h.exchange.Connections(); n < 3 {
+		t.Errorf("got %v connections, want at least 3 after the rotations", n)
+	}
+	if n := h.exchange.Closes(); n < 2 {
+		t.Errorf("got %v closing handshakes, want the replaced connections closed", n)
+	}
+}
+
+func TestPoolUpdateSubscribesLive(t *testing.T) {
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	h.pool.Update([]string{"BTCUSDT", "ETHUSDT"})
+	h.waitFor(t, "subscription", func() bool { return len(h.exchange.Requests()) > 0 })
+
+	request := h.exchange.Requests()[0]
+	if request.Method != "SUBSCRIBE" || len(request.Params) != 1 || request.Params[0] != "ethusdt@aggTrade" {
+		t.Fatalf("unexpected request: %+v", request)
+	}
+
+	h.exchange.Trade(aggTrade("ETHUSDT", 7))
+
+	assertTradeIDs(t, h.waitTrades(t, 1), "7")
+
+	if n := h.exchange.Connections(); n != 1 {
+		t.Errorf("update opened %v connections", n)
+	}
+}
+
+func TestPipelineCancelDrainsTrades(t *testing.T) {
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	want := make([]string, 0)
+	for id := int64(1); id <= 50; id++ {
+		h.exchange.Trade(aggTrade("BTCUSDT", id))
+		want = append(want, strconv.FormatInt(id, 10))
+	}
+
+	h.cancel()
+	sort.Strings(want)
+
+	select {
+	case <-h.pushed:
+	case <-time.After(e2eTimeout):
+		t.Fatal("timed out waiting for the events to end")
+	}
+
+	// Frames sent before the close frame of the exchange are all emitted
+	assertTradeIDs(t, h.waitTrades(t, 50), want...)
+
+	if n := h.exchange.Closes(); n != 1 {
+		t.Errorf("got %v closing handshakes, want 1", n)
+	}
+	if n := h.exchange.Connections(); n != 1 {
+		t.Errorf("close caused %v connections", n)
+	}
+}
+
+func TestPipelineHonorsRetryAfter(t *testing.T) {
+	h := startHarness(t, fakeSymbol("BTC", "USDT"))
+
+	h.exchange.Reject(http.StatusTooManyRequests, "1")
+	dropped := time.Now()
+	h.exchange.Drop()
+
+	h.waitFor(t, "reconnect", func() bool { return h.exchange.Connections() == 2 })
+
+	if n := h.exchange.Rejected(); n != 1 {
+		t.Errorf("got %v rejected handshakes, want 1", n)
+	}
+	if d := time.Since(dropped); d < time.Second {
+		t.Errorf("reconnected after %v despite Retry-After of 1s", d)
+	}
+}
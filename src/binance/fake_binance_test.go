@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	grpc "google.golang.org/grpc"
+
+	"collector"
+)
+
+// Fake Binance exchange serving the exchange info, the aggregated trades history and combined streams.
+// Trades are scripted by the test and delivered to every connection subscribed to their stream.
+type fakeBinance struct {
+	*httptest.Server
+	info     ExchangeInfo
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	conns    map[*fakeStreamConn]bool
+	accepted int
+	rejects  []fakeReject
+	rejected int
+	closes   int
+	trades   map[string][]AggregatedTrade
+	requests []subscriptionRequest
+	resume   time.Time
+	silent   time.Time
+}
+
+// Websocket connection of the fake exchange, frames are written by a separate goroutine
+// so the test is never blocked by a slow or stalled client. A nil frame confirms the close
+// handshake after the frames queued before it.
+type fakeStreamConn struct {
+	ws      *websocket.Conn
+	streams map[string]bool
+	frames  chan []byte
+}
+
+// Handshake answered with the status instead of the upgrade
+type fakeReject struct {
+	status     int
+	retryAfter string
+}
+
+func newFakeBinance(t *testing.T, symbols ...Symbol) *fakeBinance {
+	f := &fakeBinance{
+		info:   ExchangeInfo{Timezone: "UTC", Symbols: symbols},
+		conns:  make(map[*fakeStreamConn]bool),
+		trades: make(map[string][]AggregatedTrade),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/exchangeInfo", f.serveExchangeInfo)
+	mux.HandleFunc("/api/v3/aggTrades", f.serveAggTrades)
+	mux.HandleFunc("/stream", f.serveStream)
+
+	// Closing the server stops accepting connections, the open ones stay up until the test binary exits
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Server.Close)
+
+	return f
+}
+
+func fakeSymbol(base string, quote string) Symbol {
+	return Symbol{
+		Symbol:               base + quote,
+		Status:               "TRADING",
+		BaseAsset:            base,
+		QuoteAsset:           quote,
+		IsSpotTradingAllowed: true,
+		Permissions:          []string{"SPOT"},
+	}
+}
+
+// StreamURL returns the websocket endpoint to use instead of the Binance streams
+func (f *fakeBinance) StreamURL() string {
+	return "ws" + strings.TrimPrefix(f.URL, "http")
+}
+
+// Trade adds the trade to the history and sends it to the subscribed connections
+func (f *fakeBinance) Trade(trade AggregatedTrade) {
+	trade.EventType = "aggTrade"
+	stream := strings.ToLower(trade.Symbol) + "@aggTrade"
+
+	data, _ := json.Marshal(StreamEvent{Stream: stream, Payload: trade})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.trades[trade.Symbol] = append(f.trades[trade.Symbol], trade)
+
+	for conn := range f.conns {
+		if conn.streams[stream] {
+			conn.frames <- data
+		}
+	}
+}
+
+// SendRaw sends the frame as is to all connections, e.g. to simulate malformed JSON
+func (f *fakeBinance) SendRaw(data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		conn.frames <- data
+	}
+}
+
+// Drop closes all connections without the closing handshake
+func (f *fakeBinance) Drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		conn.ws.UnderlyingConn().Close()
+	}
+}
+
+// Stall holds back all frames for the duration, the connections stay open
+func (f *fakeBinance) Stall(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resume = time.Now().Add(d)
+}
+
+// Silence holds back all frames and ignores the pings for the duration, like a half-open connection
+func (f *fakeBinance) Silence(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resume = time.Now().Add(d)
+	f.silent = f.resume
+}
+
+// Reject answers the next stream handshake with the status and Retry-After header
+func (f *fakeBinance) Reject(status int, retryAfter string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejects = append(f.rejects, fakeReject{status: status, retryAfter: retryAfter})
+}
+
+// Rejected returns the number of rejected stream handshakes
+func (f *fakeBinance) Rejected() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rejected
+}
+
+// Connections returns the number of accepted websocket connections
+func (f *fakeBinance) Connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accepted
+}
+
+// Closes returns the number of closing handshakes started by the clients
+func (f *fakeBinance) Closes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closes
+}
+
+// Requests returns the subscription requests received on all connections
+func (f *fakeBinance) Requests() []subscriptionRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]subscriptionRequest(nil), f.requests...)
+}
+
+func (f *fakeBinance) serveExchangeInfo(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(f.info)
+}
+
+func (f *fakeBinance) serveAggTrades(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fromID, _ := strconv.ParseInt(query.Get("fromId"), 10, 64)
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	trades := make([]AggregatedTrade, 0)
+	for _, trade := range f.trades[query.Get("symbol")] {
+		if trade.AggTradeID >= fromID && (limit == 0 || len(trades) < limit) {
+			trades = append(trades, trade)
+		}
+	}
+
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].AggTradeID < trades[j].AggTradeID
+	})
+
+	json.NewEncoder(w).Encode(trades)
+}
+
+func (f *fakeBinance) serveStream(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	if len(f.rejects) > 0 {
+		reject := f.rejects[0]
+		f.rejects = f.rejects[1:]
+		f.rejected++
+		f.mu.Unlock()
+
+		w.Header().Set("Retry-After", reject.retryAfter)
+		http.Error(w, http.StatusText(reject.status), reject.status)
+		return
+	}
+	f.mu.Unlock()
+
+	ws, err := f.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	conn := &fakeStreamConn{
+		ws:      ws,
+		streams: make(map[string]bool),
+		frames:  make(chan []byte, 1024),
+	}
+
+	for _, stream := range strings.Split(r.URL.Query().Get("streams"), "/") {
+		conn.streams[stream] = true
+	}
+
+	f.mu.Lock()
+	f.conns[conn] = true
+	f.accepted++
+	f.mu.Unlock()
+
+	ws.SetCloseHandler(func(code int, text string) error {
+		f.mu.Lock()
+		f.closes++
+		f.mu.Unlock()
+
+		conn.frames <- nil
+		return nil
+	})
+
+	ws.SetPingHandler(func(data string) error {
+		f.mu.Lock()
+		silent := time.Now().Before(f.silent)
+		f.mu.Unlock()
+
+		if silent {
+			return nil
+		}
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	go f.write(conn)
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+
+		close(conn.frames)
+	}()
+
+	for {
+		var request subscriptionRequest
+		if err := ws.ReadJSON(&request); err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.requests = append(f.requests, request)
+		for _, stream := range request.Params {
+			conn.streams[stream] = request.Method == "SUBSCRIBE"
+		}
+		f.mu.Unlock()
+
+		reply, _ := json.Marshal(map[string]interface{}{"result": nil, "id": request.ID})
+		conn.frames <- reply
+	}
+}
+
+func (f *fakeBinance) write(conn *fakeStreamConn) {
+	defer conn.ws.Close()
+
+	for data := range conn.frames {
+		f.mu.Lock()
+		resume := f.resume
+		f.mu.Unlock()
+
+		if wait := time.Until(resume); wait > 0 {
+			<-time.After(wait)
+		}
+
+		if data == nil {
+			conn.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+
+		if err := conn.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+	}
+}
+
+// Fake aggregator recording the pushed trades
+type fakeSyncService struct {
+	collector.UnimplementedSyncServiceServer
+
+	mu     sync.Mutex
+	trades []*collector.TradeRequest
+}
+
+// Starts the fake aggregator on a random port and returns its address
+func startFakeSyncService(t *testing.T) (*fakeSyncService, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &fakeSyncService{}
+	server := grpc.NewServer()
+	collector.RegisterSyncServiceServer(server, service)
+
+	go server.Serve(listener)
+
+	return service, listener.Addr().String()
+}
+
+func (s *fakeSyncService) PushTrade(ctx context.Context, trade *collector.TradeRequest) (*collector.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades = append(s.trades, trade)
+	return &collector.Empty{}, nil
+}
+
+func (s *fakeSyncService) PushTradeBatch(ctx context.Context, batch *collector.TradeBatch) (*collector.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades = append(s.trades, batch.Trades...)
+	return &collector.Empty{}, nil
+}
+
+// Trades returns the trades pushed so far
+func (s *fakeSyncService) Trades() []*collector.TradeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*collector.TradeRequest(nil), s.trades...)
+}
+
+// Reset forgets the pushed trades
+func (s *fakeSyncService) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades = nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+
+	"collector"
+)
+
+var symbolsInclude = flag.String("symbols", "", "comma separated glob patterns or /regular expressions/ of symbols to subscribe, empty subscribes all")
+var symbolsExclude = flag.String("exclude-symbols", "", "comma separated glob patterns or /regular expressions/ of symbols to skip")
+var quoteAssets = flag.String("quote-assets", "", "comma separated quote assets to subscribe, empty subscribes all")
+var tradingOnly = flag.Bool("trading-only", true, "subscribe only symbols with TRADING status")
+var spotOnly = flag.Bool("spot-only", true, "subscribe only symbols with spot trading allowed")
+var filterFile = flag.String("filter", "", "json file with the symbol filter, flags given on the command line take precedence")
+
+// Symbol filter settings as read from the filter file
+type FilterConfig struct {
+	Symbols        []string `json:"symbols"`
+	ExcludeSymbols []string `json:"excludeSymbols"`
+	QuoteAssets    []string `json:"quoteAssets"`
+	TradingOnly    bool     `json:"tradingOnly"`
+	SpotOnly       bool     `json:"spotOnly"`
+}
+
+// Decides which symbols get subscribed
+type SymbolFilter struct {
+	include     collector.PatternList
+	exclude     collector.PatternList
+	quoteAssets map[string]bool
+	tradingOnly bool
+	spotOnly    bool
+}
+
+// LoadFilterConfig reads the filter settings from the filter file and the command line flags
+func LoadFilterConfig() (FilterConfig, error) {
+	config := filterConfigFromFlags()
+
+	if *filterFile == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(*filterFile)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+
+	flags := filterConfigFromFlags()
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "symbols":
+			config.Symbols = flags.Symbols
+		case "exclude-symbols":
+			config.ExcludeSymbols = flags.ExcludeSymbols
+		case "quote-assets":
+			config.QuoteAssets = flags.QuoteAssets
+		case "trading-only":
+			config.TradingOnly = flags.TradingOnly
+		case "spot-only":
+			config.SpotOnly = flags.SpotOnly
+		}
+	})
+
+	return config, nil
+}
+
+func filterConfigFromFlags() FilterConfig {
+	return FilterConfig{
+		Symbols:        collector.SplitList(*symbolsInclude),
+		ExcludeSymbols: collector.SplitList(*symbolsExclude),
+		QuoteAssets:    collector.SplitList(*quoteAssets),
+		TradingOnly:    *tradingOnly,
+		SpotOnly:       *spotOnly,
+	}
+}
+
+func NewSymbolFilter(config FilterConfig) (*SymbolFilter, error) {
+	include, err := collector.ParsePatterns(config.Symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := collector.ParsePatterns(config.ExcludeSymbols)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]bool)
+	for _, asset := range config.QuoteAssets {
+		quotes[collector.NormalizeAsset(asset)] = true
+	}
+
+	return &SymbolFilter{
+		include:     include,
+		exclude:     exclude,
+		quoteAssets: quotes,
+		tradingOnly: config.TradingOnly,
+		spotOnly:    config.SpotOnly,
+	}, nil
+}
+
+// Allow reports whether the symbol should be subscribed
+func (f *SymbolFilter) Allow(s Symbol) bool {
+	if f.tradingOnly && s.Status != "TRADING" {
+		return false
+	}
+
+	if f.spotOnly && !s.IsSpotTradingAllowed {
+		return false
+	}
+
+	if len(f.quoteAssets) > 0 && !f.quoteAssets[collector.NormalizeAsset(s.QuoteAsset)] {
+		return false
+	}
+
+	if len(f.include) > 0 && !f.include.Match(s.Symbol) {
+		return false
+	}
+
+	return !f.exclude.Match(s.Symbol)
+}
+
+// Filter returns the allowed symbols
+func (f *SymbolFilter) Filter(symbols []Symbol) []Symbol {
+	allowed := make([]Symbol, 0, len(symbols))
+
+	for _, s := range symbols {
+		if f.Allow(s) {
+			allowed = append(allowed, s)
+		}
+	}
+
+	return allowed
+}
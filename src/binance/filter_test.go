@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymbolFilter(t *testing.T) {
+	halted := fakeSymbol("LTC", "USDT")
+	halted.Status = "BREAK"
+
+	margin := fakeSymbol("ADA", "USDT")
+	margin.IsSpotTradingAllowed = false
+
+	for _, test := range []struct {
+		name   string
+		config FilterConfig
+		symbol Symbol
+		allow  bool
+	}{
+		{"no filter", FilterConfig{}, fakeSymbol("BTC", "USDT"), true},
+		{"glob include", FilterConfig{Symbols: []string{"BTC*"}}, fakeSymbol("BTC", "USDT"), true},
+		{"glob include miss", FilterConfig{Symbols: []string{"BTC*"}}, fakeSymbol("ETH", "USDT"), false},
+		{"regex include", FilterConfig{Symbols: []string{"/^(ETH|BTC)USDT$/"}}, fakeSymbol("ETH", "USDT"), true},
+		{"regex include miss", FilterConfig{Symbols: []string{"/^(ETH|BTC)USDT$/"}}, fakeSymbol("ETH", "BTC"), false},
+		{"exclude over include", FilterConfig{Symbols: []string{"*USDT"}, ExcludeSymbols: []string{"ETH*"}}, fakeSymbol("ETH", "USDT"), false},
+		{"quote allowlist", FilterConfig{QuoteAssets: []string{"usdt", "BUSD"}}, fakeSymbol("BTC", "BUSD"), true},
+		{"quote allowlist miss", FilterConfig{QuoteAssets: []string{"USDT"}}, fakeSymbol("ETH", "BTC"), false},
+		{"trading only", FilterConfig{TradingOnly: true}, halted, false},
+		{"halted allowed", FilterConfig{}, halted, true},
+		{"spot only", FilterConfig{SpotOnly: true}, margin, false},
+	} {
+		filter, err := NewSymbolFilter(test.config)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		if got := filter.Allow(test.symbol); got != test.allow {
+			t.Errorf("%v: got %v for %v, want %v", test.name, got, test.symbol.Symbol, test.allow)
+		}
+	}
+}
+
+func TestLoadFilterConfigFlagsOverrideFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.json")
+	err := ioutil.WriteFile(path, []byte(`{"symbols":["BTC*"],"quoteAssets":["BUSD"],"tradingOnly":false,"spotOnly":true}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldFile, oldQuotes := *filterFile, *quoteAssets
+	t.Cleanup(func() { *filterFile, *quoteAssets = oldFile, oldQuotes })
+
+	*filterFile = path
+	// Given on the command line
+	if err := flag.Set("quote-assets", "USDT,USDC"); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadFilterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Symbols) != 1 || config.Symbols[0] != "BTC*" {
+		t.Errorf("got symbols %v, want the ones of the file", config.Symbols)
+	}
+	if len(config.QuoteAssets) != 2 || config.QuoteAssets[0] != "USDT" {
+		t.Errorf("got quote assets %v, want the ones of the flag", config.QuoteAssets)
+	}
+	if config.TradingOnly || !config.SpotOnly {
+		t.Errorf("got trading only %v and spot only %v, want the settings of the file", config.TradingOnly, config.SpotOnly)
+	}
+}
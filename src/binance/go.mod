@@ -3,6 +3,7 @@ module binance
 go 1.16
 
 require (
+	collector v0.0.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/prometheus/client_golang v1.10.0
 	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1 // indirect
@@ -11,3 +12,5 @@ require (
 	google.golang.org/grpc v1.37.0
 	google.golang.org/protobuf v1.26.0
 )
+
+replace collector => ../collector
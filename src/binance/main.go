@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"collector"
 )
 
 var binanceApi = flag.String("binance", "https://api.binance.com", "binance rest api")
+var staleTiers = flag.String("stale-tiers", "*=30m", "comma separated pattern=threshold tiers, symbols without events for longer than the threshold of the first matching pattern are resubscribed, empty disables the detection")
 
 type Symbol struct {
 	Symbol                     string      `json:"symbol"`
@@ -31,54 +38,193 @@ type Symbol struct {
 	Permissions                []string    `json:"permissions"`
 }
 
+// Instrument returns the exchange independent description of the symbol
+func (s Symbol) Instrument() collector.Instrument {
+	return collector.NewInstrument(s.BaseAsset, s.QuoteAsset)
+}
+
 type ExchangeInfo struct {
 	Timezone        string      `json:"timezone"`
 	ServerTime      int64       `json:"serverTime"`
-	RateLimits      interface{} `json:"rateLimits"`
+	RateLimits      []RateLimit `json:"rateLimits"`
 	ExchangeFilters interface{} `json:"exchangeFilters"`
 	Symbols         []Symbol    `json:"symbols"`
 }
 
 func main() {
+	flag.Parse()
+
+	books := NewOrderBookManager(*binanceApi, *depthLevels)
+
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
+		http.Handle("/book", books)
+		http.HandleFunc("/healthz", collector.DefaultHealth.ServeLive)
+		http.HandleFunc("/readyz", collector.DefaultHealth.ServeReady)
 		http.ListenAndServe(":2112", nil)
 	}()
 
+	filterConfig, err := LoadFilterConfig()
+	if err != nil {
+		log.Fatalln("Load symbol filter:", err)
+	}
+
+	filter, err := NewSymbolFilter(filterConfig)
+	if err != nil {
+		log.Fatalln("Parse symbol filter:", err)
+	}
+
+	tiers, err := collector.ParseStalenessTiers(*staleTiers)
+	if err != nil {
+		log.Fatalln("Parse staleness tiers:", err)
+	}
+
+	sink, err := collector.OpenSinks(*collector.Sinks, "binance")
+	if err != nil {
+		log.Fatalln("Open sinks:", err)
+	}
+
+	if *collector.ReplayFile != "" {
+		replay(*collector.ReplayFile, sink)
+		sink.Close()
+		return
+	}
+
+	if *collector.RecordFile != "" {
+		collector.Recorder, err = collector.CreateCapture(*collector.RecordFile)
+		if err != nil {
+			log.Fatalln("Create capture:", err)
+		}
+		defer collector.Recorder.Close()
+	}
+
 	exchangeInfo := fetchExchangeInfo(*binanceApi)
+	exchangeInfo.Symbols = filter.Filter(exchangeInfo.Symbols)
+	log.Println("Subscribing to symbols:", len(exchangeInfo.Symbols))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := CreateBinanceStreamPool(ctx, exchangeInfo, ParseDepthSymbols(*depthSymbols))
+	stop := collector.ShutdownSignal()
+
+	collector.DefaultHealth.AddChecker("sink", sink)
+	collector.DefaultHealth.AddChecker("websocket", pool)
+
+	if len(tiers) > 0 {
+		go pool.WatchStaleness(ctx, tiers)
+	}
 
-	grpcClient := ConnectGRPC()
-	defer grpcClient.Close()
+	go books.Run(pool.DepthEvents())
 
-	pool := CreateBinanceStreamPool(exchangeInfo)
-	defer pool.Close()
+	instruments := NewInstruments(exchangeInfo.Symbols)
 
-	for event := range pool.Events() {
-		grpcClient.PushTrades(&TradeRequest{
-			Price:     event.Payload.Price,
-			Quantity:  event.Payload.Quantity,
-			TradeTime: float64(event.Payload.TradeTime) / 1000,
-			Symbol:    event.Payload.Symbol,
-			Exchange:  "binance",
-		})
+	if *refreshInterval > 0 {
+		go refreshSymbols(ctx, *binanceApi, *refreshInterval, filter, exchangeInfo.Symbols, pool, instruments)
+	}
+
+	pushed := make(chan struct{})
+
+	go func() {
+		pushTrades(pool.Events(), instruments, sink)
+		close(pushed)
+	}()
+
+	<-stop
+	deadline := time.Now().Add(*collector.ShutdownTimeout)
+
+	// The events end once the streams complete the closing handshake, the sinks then flush or spool the rest.
+	// They are closed even when the streams miss the deadline, the trades pushed afterwards are dropped.
+	cancel()
+	collector.Drain(deadline, "streams", func() { <-pushed })
+	sink.Close()
+}
+
+// Feeds the recorded frames through the trade pipeline instead of connecting to the streams
+func replay(path string, sink collector.TradeSink) {
+	info, err := requestExchangeInfo(*binanceApi)
+	if err != nil {
+		log.Println("Fetch exchanges, instruments are not resolved:", err)
+	}
+
+	events := make(chan StreamEvent)
+	depth := make(chan DepthEvent)
+
+	go replayBinanceStream(path, *collector.ReplaySpeed, events, depth)
+
+	// Order books need fresh snapshots from the api, so recorded depth updates are only parsed
+	go func() {
+		for range depth {
+		}
+	}()
+
+	pushTrades(events, NewInstruments(info.Symbols), sink)
+}
+
+func pushTrades(events chan StreamEvent, instruments *Instruments, sink collector.TradeSink) {
+	for event := range events {
+		trade := newTradeRequest(event.Payload)
+		instruments.Get(trade.Symbol).Apply(trade)
+		sink.Push(trade)
+	}
+}
+
+func newTradeRequest(trade AggregatedTrade) *collector.TradeRequest {
+	// Binance reports whether the buyer was the maker, so the aggressor is the other side
+	side := collector.Side_SIDE_BUY
+	if trade.BuyerMarketMaker {
+		side = collector.Side_SIDE_SELL
+	}
+
+	// The aggregated trade id is unique per symbol, so it serves as the idempotency key as well
+	id := strconv.FormatInt(trade.AggTradeID, 10)
+
+	return &collector.TradeRequest{
+		Price:        trade.Price,
+		Quantity:     trade.Quantity,
+		TradeTime:    float64(trade.TradeTime) / 1000,
+		Symbol:       trade.Symbol,
+		Exchange:     "binance",
+		TradeId:      id,
+		FirstTradeId: trade.FirstTradeID,
+		LastTradeId:  trade.LastTradeID,
+		Side:         side,
+		EventTime:    float64(trade.EventTIme) / 1000,
+		TradeKey:     id,
 	}
 }
 
 func fetchExchangeInfo(addr string) ExchangeInfo {
-	url := *binanceApi + "/api/v3/exchangeInfo"
-	log.Println("Fetching assets from:", url)
-	resp, err := http.Get(url)
+	info, err := requestExchangeInfo(addr)
 
 	if err != nil {
 		log.Fatalln("Fetch exchanges:", err)
 	}
 
+	return info
+}
+
+func requestExchangeInfo(addr string) (ExchangeInfo, error) {
+	url := addr + "/api/v3/exchangeInfo"
+	log.Println("Fetching assets from:", url)
+
 	var info ExchangeInfo
-	err = json.NewDecoder(resp.Body).Decode(&info)
+	resp, err := binanceGet(context.Background(), url, WEIGHT_EXCHANGE_INFO)
 
 	if err != nil {
-		log.Fatalln("Parse exchanges:", err)
+		return info, err
 	}
 
-	return info
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return info, errors.New(resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err == nil {
+		binanceLimiter.Update(info.RateLimits)
+	}
+
+	return info, err
 }
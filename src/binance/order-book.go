@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Returned by ApplyDiff when the diff does not continue the book sequence
+var ErrDepthGap = errors.New("depth update sequence gap")
+
+// Single price level of the order book
+type PriceLevel struct {
+	Price    string `json:"price"`
+	Quantity string `json:"quantity"`
+}
+
+// Snapshot of the order book as returned by /api/v3/depth
+type DepthSnapshot struct {
+	LastUpdateID int64       `json:"lastUpdateId"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+}
+
+// Local copy of the Binance order book for a single symbol
+type OrderBook struct {
+	mu           sync.RWMutex
+	symbol       string
+	lastUpdateID int64
+	bids         map[string]string
+	asks         map[string]string
+}
+
+// NewOrderBook creates order book initialized from the REST snapshot
+func NewOrderBook(symbol string, snapshot DepthSnapshot) *OrderBook {
+	book := &OrderBook{
+		symbol:       symbol,
+		lastUpdateID: snapshot.LastUpdateID,
+		bids:         make(map[string]string),
+		asks:         make(map[string]string),
+	}
+
+	applyLevels(book.bids, snapshot.Bids)
+	applyLevels(book.asks, snapshot.Asks)
+
+	return book
+}
+
+// Symbol returns the symbol of the book
+func (b *OrderBook) Symbol() string {
+	return b.symbol
+}
+
+// LastUpdateID returns the last update id applied to the book
+func (b *OrderBook) LastUpdateID() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastUpdateID
+}
+
+// ApplyDiff applies a depth update to the book following the Binance sequencing rules.
+// Updates that are already contained in the book are ignored, updates that skip
+// one or more update ids are rejected with ErrDepthGap.
+func (b *OrderBook) ApplyDiff(update DepthUpdate) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if update.FinalUpdateID <= b.lastUpdateID {
+		return false, nil
+	}
+
+	if update.FirstUpdateID > b.lastUpdateID+1 {
+		return false, ErrDepthGap
+	}
+
+	applyLevels(b.bids, update.Bids)
+	applyLevels(b.asks, update.Asks)
+	b.lastUpdateID = update.FinalUpdateID
+
+	return true, nil
+}
+
+// Top returns up to n best bids and asks
+func (b *OrderBook) Top(n int) ([]PriceLevel, []PriceLevel) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	bids := topLevels(b.bids, n, func(a, b float64) bool { return a > b })
+	asks := topLevels(b.asks, n, func(a, b float64) bool { return a < b })
+
+	return bids, asks
+}
+
+func applyLevels(side map[string]string, levels [][2]string) {
+	for _, level := range levels {
+		price, quantity := level[0], level[1]
+		// Zero quantity means the price level should be removed
+		if q, err := strconv.ParseFloat(quantity, 64); err == nil && q == 0 {
+			delete(side, price)
+			continue
+		}
+		side[price] = quantity
+	}
+}
+
+func topLevels(side map[string]string, n int, better func(a, b float64) bool) []PriceLevel {
+	type level struct {
+		price float64
+		PriceLevel
+	}
+
+	levels := make([]level, 0, len(side))
+	for price, quantity := range side {
+		p, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			continue
+		}
+		levels = append(levels, level{p, PriceLevel{Price: price, Quantity: quantity}})
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		return better(levels[i].price, levels[j].price)
+	})
+
+	if n > 0 && len(levels) > n {
+		levels = levels[:n]
+	}
+
+	result := make([]PriceLevel, len(levels))
+	for i, l := range levels {
+		result[i] = l.PriceLevel
+	}
+
+	return result
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestOrderBookApplyDiff(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		update  DepthUpdate
+		applied bool
+		err     error
+		last    int64
+		bids    int
+	}{
+		{"stale update", DepthUpdate{FirstUpdateID: 90, FinalUpdateID: 100, Bids: [][2]string{{"9.0", "1"}}}, false, nil, 100, 1},
+		{"first event overlapping the snapshot", DepthUpdate{FirstUpdateID: 95, FinalUpdateID: 105, Bids: [][2]string{{"9.0", "1"}}}, true, nil, 105, 2},
+		{"next update", DepthUpdate{FirstUpdateID: 101, FinalUpdateID: 101, Bids: [][2]string{{"9.0", "1"}}}, true, nil, 101, 2},
+		{"gap", DepthUpdate{FirstUpdateID: 102, FinalUpdateID: 110, Bids: [][2]string{{"9.0", "1"}}}, false, ErrDepthGap, 100, 1},
+		{"level removed", DepthUpdate{FirstUpdateID: 101, FinalUpdateID: 102, Bids: [][2]string{{"10.0", "0.00000000"}}}, true, nil, 102, 0},
+	} {
+		book := NewOrderBook("BTCUSDT", DepthSnapshot{
+			LastUpdateID: 100,
+			Bids:         [][2]string{{"10.0", "1"}},
+			Asks:         [][2]string{{"11.0", "1"}},
+		})
+
+		applied, err := book.ApplyDiff(test.update)
+		if applied != test.applied || err != test.err {
+			t.Errorf("%v: got %v, %v, want %v, %v", test.name, applied, err, test.applied, test.err)
+		}
+
+		bids, asks := book.Top(0)
+		if book.LastUpdateID() != test.last || len(bids) != test.bids || len(asks) != 1 {
+			t.Errorf("%v: got last update %v, bids %v and asks %v", test.name, book.LastUpdateID(), bids, asks)
+		}
+	}
+}
+
+func TestOrderBookTop(t *testing.T) {
+	book := NewOrderBook("BTCUSDT", DepthSnapshot{
+		Bids: [][2]string{{"9.5", "1"}, {"10.0", "2"}, {"9.0", "3"}},
+		Asks: [][2]string{{"11.0", "1"}, {"10.5", "2"}},
+	})
+
+	bids, asks := book.Top(2)
+	if len(bids) != 2 || bids[0].Price != "10.0" || bids[1].Price != "9.5" {
+		t.Errorf("got bids %v", bids)
+	}
+	if len(asks) != 2 || asks[0].Price != "10.5" || asks[1].Price != "11.0" {
+		t.Errorf("got asks %v", asks)
+	}
+}
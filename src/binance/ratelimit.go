@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Types of the limits applied to the collector, the order limits don't matter for market data
+const (
+	RATE_LIMIT_REQUEST_WEIGHT = "REQUEST_WEIGHT"
+	RATE_LIMIT_RAW_REQUESTS   = "RAW_REQUESTS"
+	RATE_LIMIT_CONNECTIONS    = "CONNECTIONS"
+)
+
+// Request weights of the used endpoints, the used weight headers correct the count if they change
+const (
+	WEIGHT_EXCHANGE_INFO = 10
+	WEIGHT_AGG_TRADES    = 1
+	WEIGHT_DEPTH         = 50 // At the snapshot limit of 1000, limits from 501 to 1000 weigh 50
+)
+
+// Limits applied until the exchange info tells the actual ones, the exchange info doesn't list
+// the websocket connection limit of 300 connections per 5 minutes
+var defaultRateLimits = []RateLimit{
+	{RateLimitType: RATE_LIMIT_REQUEST_WEIGHT, Interval: "MINUTE", IntervalNum: 1, Limit: 1200},
+	{RateLimitType: RATE_LIMIT_RAW_REQUESTS, Interval: "MINUTE", IntervalNum: 5, Limit: 6100},
+	{RateLimitType: RATE_LIMIT_CONNECTIONS, Interval: "MINUTE", IntervalNum: 5, Limit: 300},
+}
+
+var (
+	binance_rate_limit_used = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "binance_rate_limit_used",
+		Help: "The usage of the rate limit within the current window by limit type and window",
+	}, []string{"type", "window"})
+	binance_rate_limit_wait_seconds_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_rate_limit_wait_seconds_total",
+		Help: "The total time requests and connection attempts waited for the rate limits",
+	})
+)
+
+// Shared by all REST requests and websocket connection attempts of the process
+var binanceLimiter = NewRateLimiter(defaultRateLimits)
+
+// Limit as listed by the exchange info
+type RateLimit struct {
+	RateLimitType string `json:"rateLimitType"`
+	Interval      string `json:"interval"`
+	IntervalNum   int    `json:"intervalNum"`
+	Limit         int    `json:"limit"`
+}
+
+var rateLimitIntervals = map[string]time.Duration{
+	"SECOND": time.Second,
+	"MINUTE": time.Minute,
+	"HOUR":   time.Hour,
+	"DAY":    24 * time.Hour,
+}
+
+// Window returns the length of the limit window, zero for unknown intervals
+func (l RateLimit) Window() time.Duration {
+	return rateLimitIntervals[l.Interval] * time.Duration(l.IntervalNum)
+}
+
+// Name of the window as used by the used weight headers, e.g. 1M
+func (l RateLimit) windowName() string {
+	return fmt.Sprintf("%v%v", l.IntervalNum, l.Interval[:1])
+}
+
+// Usage of the limit within the current window, Binance counts in fixed windows
+type rateWindow struct {
+	limit RateLimit
+	start time.Time
+	used  int
+}
+
+func (w *rateWindow) roll(now time.Time) {
+	if start := now.Truncate(w.limit.Window()); start.After(w.start) {
+		w.start = start
+		w.used = 0
+	}
+}
+
+// Returns the wait until the window has room for n more
+func (w *rateWindow) wait(now time.Time, n int) time.Duration {
+	w.roll(now)
+
+	if w.used+n <= w.limit.Limit {
+		return 0
+	}
+
+	return w.start.Add(w.limit.Window()).Sub(now)
+}
+
+func (w *rateWindow) report() {
+	binance_rate_limit_used.WithLabelValues(w.limit.RateLimitType, w.limit.windowName()).Set(float64(w.used))
+}
+
+// Keeps the requests and connection attempts within the limits of the exchange
+type RateLimiter struct {
+	mu      sync.Mutex
+	windows []*rateWindow
+}
+
+func NewRateLimiter(limits []RateLimit) *RateLimiter {
+	limiter := &RateLimiter{}
+	limiter.Update(limits)
+	return limiter
+}
+
+// Update applies the limits of the listed types, the limits of the other types stay as they are.
+// The usage of the windows which didn't change is kept.
+func (l *RateLimiter) Update(limits []RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	updated := make(map[string]bool)
+	windows := make([]*rateWindow, 0)
+
+	for _, limit := range limits {
+		switch limit.RateLimitType {
+		case RATE_LIMIT_REQUEST_WEIGHT, RATE_LIMIT_RAW_REQUESTS, RATE_LIMIT_CONNECTIONS:
+		default:
+			continue
+		}
+
+		if limit.Window() <= 0 || limit.Limit <= 0 {
+			continue
+		}
+
+		updated[limit.RateLimitType] = true
+		window := &rateWindow{limit: limit}
+
+		for _, old := range l.windows {
+			if old.limit.RateLimitType == limit.RateLimitType && old.limit.Window() == limit.Window() {
+				window.start, window.used = old.start, old.used
+			}
+		}
+
+		windows = append(windows, window)
+	}
+
+	for _, old := range l.windows {
+		if !updated[old.limit.RateLimitType] {
+			windows = append(windows, old)
+		}
+	}
+
+	l.windows = windows
+}
+
+// Request waits until the request of the weight fits the weight and raw request limits
+func (l *RateLimiter) Request(ctx context.Context, weight int) error {
+	return l.acquire(ctx, map[string]int{
+		RATE_LIMIT_REQUEST_WEIGHT: weight,
+		RATE_LIMIT_RAW_REQUESTS:   1,
+	})
+}
+
+// Connect waits until the websocket connection attempt fits the connection limits
+func (l *RateLimiter) Connect(ctx context.Context) error {
+	return l.acquire(ctx, map[string]int{RATE_LIMIT_CONNECTIONS: 1})
+}
+
+// Observe syncs the used weight with the X-MBX-USED-WEIGHT headers of the response,
+// the exchange also counts the requests of other processes behind the same IP
+func (l *RateLimiter) Observe(resp *http.Response) {
+	exchangeCircuit.Observe(resp)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for _, w := range l.windows {
+		if w.limit.RateLimitType != RATE_LIMIT_REQUEST_WEIGHT {
+			continue
+		}
+
+		header := resp.Header.Get("X-MBX-USED-WEIGHT-" + w.limit.windowName())
+		if header == "" && w.limit.Window() == time.Minute {
+			header = resp.Header.Get("X-MBX-USED-WEIGHT")
+		}
+
+		used, err := strconv.Atoi(header)
+		if err != nil {
+			continue
+		}
+
+		w.roll(now)
+		if used > w.used {
+			w.used = used
+		}
+		w.report()
+	}
+}
+
+// Waits until the circuit is closed and all windows of the limit types have room for the costs
+func (l *RateLimiter) acquire(ctx context.Context, costs map[string]int) error {
+	for {
+		wait := exchangeCircuit.Remaining()
+		if wait <= 0 {
+			wait = l.reserve(time.Now(), costs)
+		}
+
+		if wait <= 0 {
+			return nil
+		}
+
+		binance_rate_limit_wait_seconds_total.Add(wait.Seconds())
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Counts the costs unless any of the windows is full, returns the wait until it has room otherwise
+func (l *RateLimiter) reserve(now time.Time, costs map[string]int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wait := time.Duration(0)
+
+	for _, w := range l.windows {
+		if n, ok := costs[w.limit.RateLimitType]; ok {
+			if d := w.wait(now, n); d > wait {
+				wait = d
+			}
+		}
+	}
+
+	if wait > 0 {
+		return wait
+	}
+
+	for _, w := range l.windows {
+		if n, ok := costs[w.limit.RateLimitType]; ok {
+			w.used += n
+			w.report()
+		}
+	}
+
+	return 0
+}
+
+// binanceGet performs the REST request once the limits have room for its weight
+func binanceGet(ctx context.Context, url string, weight int) (*http.Response, error) {
+	if err := binanceLimiter.Request(ctx, weight); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	binanceLimiter.Observe(resp)
+
+	return resp, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func weightLimit(limit int) RateLimit {
+	return RateLimit{RateLimitType: RATE_LIMIT_REQUEST_WEIGHT, Interval: "MINUTE", IntervalNum: 1, Limit: limit}
+}
+
+func TestRateLimiterWaitsForWindow(t *testing.T) {
+	limiter := NewRateLimiter([]RateLimit{weightLimit(10)})
+	now := time.Now().Truncate(time.Minute).Add(45 * time.Second)
+
+	if wait := limiter.reserve(now, map[string]int{RATE_LIMIT_REQUEST_WEIGHT: 10}); wait != 0 {
+		t.Fatalf("request within the limit waits %v", wait)
+	}
+
+	if wait := limiter.reserve(now, map[string]int{RATE_LIMIT_REQUEST_WEIGHT: 1}); wait != 15*time.Second {
+		t.Fatalf("request over the limit waits %v, want the rest of the window", wait)
+	}
+
+	// Other limit types are not affected by the full window
+	if wait := limiter.reserve(now, map[string]int{RATE_LIMIT_CONNECTIONS: 1}); wait != 0 {
+		t.Fatalf("connection waits %v for the request weight", wait)
+	}
+
+	if wait := limiter.reserve(now.Add(15*time.Second), map[string]int{RATE_LIMIT_REQUEST_WEIGHT: 10}); wait != 0 {
+		t.Fatalf("request in the next window waits %v", wait)
+	}
+}
+
+func TestRateLimiterReservesAllWindows(t *testing.T) {
+	limiter := NewRateLimiter([]RateLimit{
+		weightLimit(100),
+		{RateLimitType: RATE_LIMIT_RAW_REQUESTS, Interval: "SECOND", IntervalNum: 10, Limit: 2},
+	})
+	now := time.Now().Truncate(time.Minute)
+	request := map[string]int{RATE_LIMIT_REQUEST_WEIGHT: 5, RATE_LIMIT_RAW_REQUESTS: 1}
+
+	limiter.reserve(now, request)
+	limiter.reserve(now, request)
+
+	if wait := limiter.reserve(now, request); wait != 10*time.Second {
+		t.Fatalf("third request waits %v, want the raw request window", wait)
+	}
+
+	// The rejected request must not count against the weight
+	if used := limiter.windows[0].used; used != 10 {
+		t.Errorf("got used weight %v, want 10", used)
+	}
+}
+
+func TestRateLimiterSyncsUsedWeight(t *testing.T) {
+	limiter := NewRateLimiter([]RateLimit{weightLimit(1200)})
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+	resp.Header.Set("X-MBX-USED-WEIGHT-1M", "1195")
+	limiter.Observe(resp)
+
+	now := time.Now()
+	if now.Add(time.Second).Truncate(time.Minute).After(now.Truncate(time.Minute)) {
+		t.Skip("too close to the end of the window")
+	}
+
+	if wait := limiter.reserve(now, map[string]int{RATE_LIMIT_REQUEST_WEIGHT: 10}); wait <= 0 {
+		t.Error("request fits the weight used by the exchange")
+	}
+
+	// Lower counts of the exchange don't release the weight reserved by requests in flight
+	resp.Header.Set("X-MBX-USED-WEIGHT-1M", "5")
+	limiter.Observe(resp)
+
+	if used := limiter.windows[0].used; used != 1195 {
+		t.Errorf("got used weight %v, want 1195", used)
+	}
+}
+
+func TestRateLimiterUpdateKeepsUsage(t *testing.T) {
+	limiter := NewRateLimiter(defaultRateLimits)
+	limiter.Request(context.Background(), 10)
+	limiter.Connect(context.Background())
+
+	limiter.Update([]RateLimit{
+		weightLimit(6000),
+		{RateLimitType: RATE_LIMIT_RAW_REQUESTS, Interval: "MINUTE", IntervalNum: 1, Limit: 100},
+		{RateLimitType: "ORDERS", Interval: "SECOND", IntervalNum: 10, Limit: 50},
+	})
+
+	usage := make(map[string]int)
+	for _, w := range limiter.windows {
+		usage[w.limit.RateLimitType+" "+w.limit.windowName()] = w.used
+	}
+
+	want := map[string]int{
+		"REQUEST_WEIGHT 1M": 10,
+		"RAW_REQUESTS 1M":   0,
+		"CONNECTIONS 5M":    1,
+	}
+	if len(usage) != len(want) {
+		t.Fatalf("got windows %v, want %v", usage, want)
+	}
+	for name, used := range want {
+		if usage[name] != used {
+			t.Errorf("got windows %v, want %v", usage, want)
+		}
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter := NewRateLimiter([]RateLimit{{RateLimitType: RATE_LIMIT_REQUEST_WEIGHT, Interval: "HOUR", IntervalNum: 1, Limit: 1}})
+	limiter.Request(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.Request(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("got %v waiting for the full window, want the deadline", err)
+	}
+}
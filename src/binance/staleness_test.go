@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"collector"
+)
+
+func TestStalenessTiers(t *testing.T) {
+	tiers, err := collector.ParseStalenessTiers("BTC*=1m, /^ETH(USDT|BTC)$/=5m, *=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for symbol, want := range map[string]time.Duration{
+		"BTCUSDT": time.Minute,
+		"ETHUSDT": 5 * time.Minute,
+		"ETHBUSD": time.Hour,
+	} {
+		if got := tiers.Threshold(symbol); got != want {
+			t.Errorf("Threshold(%v) = %v, want %v", symbol, got, want)
+		}
+	}
+
+	lastEvents := map[string]time.Time{
+		"btcusdt@aggTrade": time.Now().Add(-2 * time.Minute),
+		"ethusdt@aggTrade": time.Now().Add(-2 * time.Minute),
+		"xrpusdt@aggTrade": time.Now().Add(-2 * time.Hour),
+	}
+
+	stale := tiers.Stale(lastEvents, channelSymbol)
+	if len(stale) != 2 || stale[0] != "btcusdt@aggTrade" || stale[1] != "xrpusdt@aggTrade" {
+		t.Errorf("got stale channels %v", stale)
+	}
+}
+
+func TestStalenessTiersErrors(t *testing.T) {
+	for _, spec := range []string{"BTC*", "*=soon", "*=0s", "/(/=1m"} {
+		if _, err := collector.ParseStalenessTiers(spec); err == nil {
+			t.Errorf("parsed invalid tiers %q", spec)
+		}
+	}
+
+	if tiers, err := collector.ParseStalenessTiers(""); err != nil || len(tiers) != 0 {
+		t.Errorf("empty tiers: %v, %v", tiers, err)
+	}
+}
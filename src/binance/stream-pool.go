@@ -1,28 +1,131 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"collector"
 )
 
 const MAX_STREAMS_PER_CONNECTION = 50
 
+var (
+	binance_channels_stale = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "binance_channels_stale",
+		Help: "The number of channels without events for longer than their staleness threshold",
+	})
+	binance_stale_resubscriptions_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_stale_resubscriptions_total",
+		Help: "The total number of stale channels resubscribed",
+	})
+	binance_stale_recycles_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_stale_recycles_total",
+		Help: "The total number of connections recycled because all of their channels went stale",
+	})
+)
+
 type BinanceStreamPool struct {
+	mu      sync.Mutex
+	done    <-chan struct{}
 	events  chan StreamEvent
-	streams []BinanceStream
+	depth   chan DepthEvent
+	streams []*BinanceStream
+	updates chan []string
+	running sync.WaitGroup
 }
 
-func (pool BinanceStreamPool) Events() chan StreamEvent {
+func (pool *BinanceStreamPool) Events() chan StreamEvent {
 	return pool.events
 }
 
-func (pool BinanceStreamPool) Close() {
-	for _, stream := range pool.streams {
-		stream.Close()
+func (pool *BinanceStreamPool) DepthEvents() chan DepthEvent {
+	return pool.depth
+}
+
+// WatchStaleness periodically resubscribes the stale channels, connections with all channels stale are recycled
+func (pool *BinanceStreamPool) WatchStaleness(ctx context.Context, tiers collector.StalenessTiers) {
+	ticker := time.NewTicker(collector.STALENESS_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		pool.mu.Lock()
+		streams := append([]*BinanceStream(nil), pool.streams...)
+		pool.mu.Unlock()
+
+		total := 0
+
+		for _, stream := range streams {
+			stale := stream.Stale(tiers)
+			total += len(stale)
+
+			switch {
+			case len(stale) == 0:
+				continue
+			case len(stale) == len(stream.Channels()):
+				log.Printf("All %v channels of the stream are stale, reconnecting", len(stale))
+				binance_stale_recycles_total.Inc()
+				stream.Recycle()
+			default:
+				log.Println("Resubscribing stale channels:", stale)
+				binance_stale_resubscriptions_total.Add(float64(len(stale)))
+				stream.Resubscribe(stale)
+			}
+		}
+
+		binance_channels_stale.Set(float64(total))
+	}
+}
+
+// Check fails when there are no streams or a stream stays without connection longer than the ready window,
+// a stream reconnecting in time doesn't make the service unready
+func (pool *BinanceStreamPool) Check() error {
+	pool.mu.Lock()
+	streams := append([]*BinanceStream(nil), pool.streams...)
+	pool.mu.Unlock()
+
+	connected := 0
+	longest := time.Duration(0)
+	for _, stream := range streams {
+		disconnected := stream.Disconnected()
+		if disconnected == 0 {
+			connected++
+		} else if disconnected > longest {
+			longest = disconnected
+		}
+	}
+
+	if len(streams) == 0 || longest > *collector.ReadyWindow {
+		return fmt.Errorf("%v of %v streams connected", connected, len(streams))
+	}
+
+	return nil
+}
+
+// Update changes the trade subscriptions to the given symbols, updates of a cancelled pool are dropped
+func (pool *BinanceStreamPool) Update(symbols []string) {
+	select {
+	case pool.updates <- symbols:
+	case <-pool.done:
 	}
 }
 
-func CreateBinanceStreamPool(info ExchangeInfo) BinanceStreamPool {
+// CreateBinanceStreamPool opens the streams of the channels until the context is cancelled.
+// The event channels are closed once all streams completed their closing handshake.
+func CreateBinanceStreamPool(ctx context.Context, info ExchangeInfo, depthSymbols []string) *BinanceStreamPool {
 	channels := make([]string, 0)
 
 	for _, s := range info.Symbols {
@@ -30,10 +133,51 @@ func CreateBinanceStreamPool(info ExchangeInfo) BinanceStreamPool {
 		channels = append(channels, channel)
 	}
 
-	// Need to span the channels into multiple connections
-	// TODO: increase the streams per connection as Binance allows up to 1024
+	for _, symbol := range depthSymbols {
+		channel := strings.ToLower(symbol) + DEPTH_STREAM_SUFFIX
+		channels = append(channels, channel)
+	}
+
+	pool := &BinanceStreamPool{
+		done:    ctx.Done(),
+		events:  make(chan StreamEvent),
+		depth:   make(chan DepthEvent),
+		streams: make([]*BinanceStream, 0),
+		updates: make(chan []string),
+	}
+
+	go pool.run(ctx, channels)
+
+	return pool
+}
+
+// Opens the initial streams and applies the subscription updates one at a time.
+// The streams are only added here, so the forwarders are all registered before the wait.
+func (pool *BinanceStreamPool) run(ctx context.Context, channels []string) {
+	defer func() {
+		pool.running.Wait()
+		close(pool.events)
+		close(pool.depth)
+	}()
+
+	assigned := make(map[string]*BinanceStream)
+
+	pool.open(ctx, channels, assigned)
+
+	for {
+		select {
+		case symbols := <-pool.updates:
+			pool.apply(ctx, symbols, assigned)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Splits the channels into chunks of at most the chunk size.
+// The chunks are capped, so the subscriptions appended to a stream don't overwrite the channels of the next one.
+func chunkChannels(channels []string, chunkSize int) [][]string {
 	channelChunks := make([][]string, 0)
-	chunkSize := MAX_STREAMS_PER_CONNECTION
 
 	for {
 		if len(channels) == 0 {
@@ -42,31 +186,119 @@ func CreateBinanceStreamPool(info ExchangeInfo) BinanceStreamPool {
 		if len(channels) < chunkSize {
 			chunkSize = len(channels)
 		}
-		channelChunks = append(channelChunks, channels[0:chunkSize])
+		channelChunks = append(channelChunks, channels[0:chunkSize:chunkSize])
 		channels = channels[chunkSize:]
 	}
 
-	events := make(chan StreamEvent)
-	streams := make([]BinanceStream, 0)
+	return channelChunks
+}
+
+// Opens new streams for the channels
+func (pool *BinanceStreamPool) open(ctx context.Context, channels []string, assigned map[string]*BinanceStream) {
+	// Need to span the channels into multiple connections
+	// TODO: increase the streams per connection as Binance allows up to 1024
+	for _, chunk := range chunkChannels(channels, MAX_STREAMS_PER_CONNECTION) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		stream := OpenBinanceStream(ctx, chunk)
 
-	go func() {
-		for _, chunk := range channelChunks {
-			stream := OpenBinanceStream(chunk)
-			streams = append(streams, stream)
+		pool.mu.Lock()
+		pool.streams = append(pool.streams, stream)
+		pool.mu.Unlock()
 
-			go func() {
-				for event := range stream.Events() {
-					events <- event
-				}
-			}()
+		pool.running.Add(2)
 
-			// Wait for a while before opening another stream so Binance won't get upset
-			<-time.After(time.Second)
+		for _, channel := range chunk {
+			assigned[channel] = stream
 		}
-	}()
 
-	return BinanceStreamPool{
-		events:  events,
-		streams: streams,
+		go func() {
+			defer pool.running.Done()
+			for event := range stream.Events() {
+				stream.Touch(event.Stream)
+				collector.DefaultHealth.Event()
+				pool.events <- event
+			}
+		}()
+
+		go func() {
+			defer pool.running.Done()
+			for event := range stream.DepthEvents() {
+				stream.Touch(event.Stream)
+				collector.DefaultHealth.Event()
+				pool.depth <- event
+			}
+		}()
+	}
+}
+
+// Subscribes the new symbols and unsubscribes the ones which are gone
+func (pool *BinanceStreamPool) apply(ctx context.Context, symbols []string, assigned map[string]*BinanceStream) {
+	wanted := make(map[string]bool)
+	for _, symbol := range symbols {
+		wanted[strings.ToLower(symbol)+"@aggTrade"] = true
+	}
+
+	removed := make(map[*BinanceStream][]string)
+	for channel, stream := range assigned {
+		if strings.HasSuffix(channel, "@aggTrade") && !wanted[channel] {
+			removed[stream] = append(removed[stream], channel)
+			delete(assigned, channel)
+		}
+	}
+
+	for stream, channels := range removed {
+		stream.Unsubscribe(channels)
+
+		if len(stream.Channels()) == 0 {
+			pool.remove(stream)
+			stream.Close()
+		}
+	}
+
+	added := make([]string, 0)
+	for channel := range wanted {
+		if _, ok := assigned[channel]; !ok {
+			added = append(added, channel)
+		}
+	}
+
+	sort.Strings(added)
+
+	// Fill the connections with free capacity before opening new ones
+	pool.mu.Lock()
+	streams := append([]*BinanceStream(nil), pool.streams...)
+	pool.mu.Unlock()
+
+	for _, stream := range streams {
+		free := MAX_STREAMS_PER_CONNECTION - len(stream.Channels())
+		if free <= 0 || len(added) == 0 {
+			continue
+		}
+		if free > len(added) {
+			free = len(added)
+		}
+
+		stream.Subscribe(added[:free])
+		for _, channel := range added[:free] {
+			assigned[channel] = stream
+		}
+		added = added[free:]
+	}
+
+	pool.open(ctx, added, assigned)
+}
+
+func (pool *BinanceStreamPool) remove(stream *BinanceStream) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for i, s := range pool.streams {
+		if s == stream {
+			pool.streams = append(pool.streams[:i], pool.streams[i+1:]...)
+			return
+		}
 	}
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"collector"
+)
+
+// Starts a pool against the fake exchange with all events consumed, the returned channel
+// is closed once the events end
+func startDrainedPool(ctx context.Context, exchange *fakeBinance, depthSymbols []string) (*BinanceStreamPool, chan struct{}) {
+	*binanceApi = exchange.URL
+	*streamAddr = exchange.StreamURL()
+
+	pool := CreateBinanceStreamPool(ctx, exchange.info, depthSymbols)
+	closed := make(chan struct{})
+
+	go func() {
+		for range pool.DepthEvents() {
+		}
+	}()
+
+	go func() {
+		for range pool.Events() {
+		}
+		close(closed)
+	}()
+
+	return pool, closed
+}
+
+// Fails unless the pool closes its event channels in time after the cancel
+func waitPoolClosed(t *testing.T, closed chan struct{}, timeout time.Duration) {
+	t.Helper()
+
+	select {
+	case <-closed:
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for the pool events to end")
+	}
+}
+
+// Fails unless the number of goroutines gets back to the baseline
+func assertNoLeaks(t *testing.T, baseline int) {
+	t.Helper()
+
+	// Keep-alive connections of the REST calls are not part of the pool
+	http.DefaultClient.CloseIdleConnections()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+			t.Fatalf("got %v goroutines, want at most %v", runtime.NumGoroutine(), baseline)
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+}
+
+func TestPoolCancelStopsAllGoroutines(t *testing.T) {
+	exchange := newFakeBinance(t, fakeSymbol("BTC", "USDT"), fakeSymbol("ETH", "USDT"))
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool, closed := startDrainedPool(ctx, exchange, []string{"BTCUSDT"})
+
+	waitUntil(t, func() bool { return exchange.Connections() == 1 })
+	exchange.Trade(aggTrade("BTCUSDT", 1))
+
+	// Exercise the reconnect with the backfill and a live subscription update
+	exchange.Drop()
+	waitUntil(t, func() bool { return exchange.Connections() == 2 })
+	pool.Update([]string{"BTCUSDT"})
+
+	cancel()
+
+	waitPoolClosed(t, closed, e2eTimeout)
+	assertNoLeaks(t, baseline)
+
+	// Updates of the cancelled pool must not block
+	pool.Update([]string{"ETHUSDT"})
+}
+
+func TestPoolCancelStopsReconnects(t *testing.T) {
+	exchange := newFakeBinance(t, fakeSymbol("BTC", "USDT"))
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, closed := startDrainedPool(ctx, exchange, nil)
+
+	waitUntil(t, func() bool { return exchange.Connections() == 1 })
+
+	// The stream waits before the next attempt as the exchange is gone
+	exchange.Server.Close()
+	exchange.Drop()
+	<-time.After(100 * time.Millisecond)
+
+	cancel()
+
+	waitPoolClosed(t, closed, time.Second)
+	assertNoLeaks(t, baseline)
+}
+
+// Polls the condition until it holds or the test times out
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(e2eTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+}
+
+func TestPoolCheckToleratesReconnects(t *testing.T) {
+	pool := &BinanceStreamPool{}
+
+	if err := pool.Check(); err == nil {
+		t.Error("ready without streams")
+	}
+
+	connected := &BinanceStream{socket: &websocket.Conn{}}
+	reconnecting := &BinanceStream{detached: time.Now()}
+	pool.streams = []*BinanceStream{connected, reconnecting}
+
+	if err := pool.Check(); err != nil {
+		t.Errorf("not ready while a stream reconnects: %v", err)
+	}
+
+	reconnecting.detached = time.Now().Add(-2 * *collector.ReadyWindow)
+
+	if err := pool.Check(); err == nil || err.Error() != "1 of 2 streams connected" {
+		t.Errorf("got %v with a stream disconnected for too long", err)
+	}
+}
+
+func TestChunkChannelsAreCapped(t *testing.T) {
+	chunks := chunkChannels([]string{"a", "b", "c", "d", "e"}, 2)
+
+	if len(chunks) != 3 || len(chunks[2]) != 1 {
+		t.Fatalf("got chunks %v", chunks)
+	}
+
+	// A subscription added to the first stream must not overwrite the channels of the second one
+	chunks[0] = append(chunks[0], "x")
+
+	if chunks[1][0] != "c" {
+		t.Errorf("appending to the first chunk changed the second one: %v", chunks[1])
+	}
+}
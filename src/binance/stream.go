@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"collector"
 )
 
+var streamAddr = flag.String("stream", "wss://stream.binance.com:9443", "binance websocket stream endpoint")
+var rotateAfter = flag.Duration("rotate-after", 23*time.Hour+50*time.Minute, "age of the connections replaced before Binance drops them after 24 hours, 0 disables the rotation")
+var rotateOverlap = flag.Duration("rotate-overlap", 10*time.Second, "time both connections are read while rotating")
+
 var (
 	binance_websocket_connections_open = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "binance_websocket_connections_open",
@@ -24,6 +34,14 @@ var (
 		Name: "binance_websocket_connection_errors",
 		Help: "The total number of processed trades",
 	})
+	binance_websocket_rotations_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_websocket_rotations_total",
+		Help: "The total number of connections replaced before the 24 hour disconnect",
+	})
+	binance_websocket_read_timeouts_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_websocket_read_timeouts_total",
+		Help: "The total number of connections reconnected because no frame arrived within the read timeout",
+	})
 	binance_websocket_streams_total = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "binance_websocket_streams_total",
 		Help: "The total number of processed trades",
@@ -49,117 +67,561 @@ type AggregatedTrade struct {
 	Ignore           bool   `json:"M"`
 }
 
+// Contains order book changes since the previous update
+type DepthUpdate struct {
+	EventType     string      `json:"e"`
+	EventTime     int64       `json:"E"`
+	Symbol        string      `json:"s"`
+	FirstUpdateID int64       `json:"U"`
+	FinalUpdateID int64       `json:"u"`
+	Bids          [][2]string `json:"b"`
+	Asks          [][2]string `json:"a"`
+}
+
 // Represents stream subscription event
 type StreamEvent struct {
 	Stream  string          `json:"stream"`
 	Payload AggregatedTrade `json:"data"`
 }
 
+// Represents depth stream subscription event
+type DepthEvent struct {
+	Stream  string      `json:"stream"`
+	Payload DepthUpdate `json:"data"`
+}
+
+// Raw combined stream frame before the payload is decoded
+type streamFrame struct {
+	Stream  string          `json:"stream"`
+	Payload json.RawMessage `json:"data"`
+}
+
 // Interface to Binance stream subscriptions
 type BinanceStream struct {
-	close    chan struct{}
-	events   chan StreamEvent
-	channels []string
+	mu         sync.Mutex
+	cancel     context.CancelFunc
+	events     chan StreamEvent
+	depth      chan DepthEvent
+	channels   []string
+	lastEvents map[string]time.Time
+	frames     *frameHandler
+	socket     *websocket.Conn
+	detached   time.Time
+	requests   int
+}
+
+// Request to change the subscriptions of a live connection
+type subscriptionRequest struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	ID     int      `json:"id"`
 }
 
 // Channels returns list of subscribed channels
-func (s BinanceStream) Channels() []string {
-	return s.channels
+func (s *BinanceStream) Channels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.channels...)
 }
 
 // ReadEvent reads the next stream event from the Binance
-func (s BinanceStream) Events() chan StreamEvent {
+func (s *BinanceStream) Events() chan StreamEvent {
 	return s.events
 }
 
-// Close closes the underlying websocket connection to Binance
-func (s BinanceStream) Close() {
-	s.close <- struct{}{}
+// DepthEvents returns the depth updates of the subscribed depth channels
+func (s *BinanceStream) DepthEvents() chan DepthEvent {
+	return s.depth
+}
+
+// Close stops the stream the same way as cancelling its context does
+func (s *BinanceStream) Close() {
+	s.cancel()
 }
 
-// OpenBinanceStream connects to binance public websocket streams
-func OpenBinanceStream(channels []string) BinanceStream {
+// Subscribe adds the channels to the live connection, they are also used on reconnects
+func (s *BinanceStream) Subscribe(channels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.channels = append(s.channels, channels...)
+	s.request("SUBSCRIBE", channels)
+	s.reset(channels)
 	binance_websocket_streams_total.Add(float64(len(channels)))
+}
+
+// Unsubscribe removes the channels from the live connection and forgets their last trade ids
+func (s *BinanceStream) Unsubscribe(channels []string) {
+	s.unsubscribe(channels)
+
+	// Not under the stream lock, the frame handler may wait for the consumer touching the stream
+	s.frames.prune(s.Channels())
+}
+
+func (s *BinanceStream) unsubscribe(channels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := make(map[string]bool)
+	for _, channel := range channels {
+		removed[channel] = true
+		delete(s.lastEvents, channel)
+	}
+
+	remaining := make([]string, 0, len(s.channels))
+	for _, channel := range s.channels {
+		if !removed[channel] {
+			remaining = append(remaining, channel)
+		}
+	}
+
+	s.channels = remaining
+	s.request("UNSUBSCRIBE", channels)
+}
+
+// Sends subscription request on the live connection, must be called with the lock held.
+// Failures are only logged since the next reconnect uses the updated channels anyway.
+func (s *BinanceStream) request(method string, channels []string) {
+	if s.socket == nil {
+		return
+	}
+
+	s.requests++
+	err := s.socket.WriteJSON(&subscriptionRequest{
+		Method: method,
+		Params: channels,
+		ID:     s.requests,
+	})
+
+	if err != nil {
+		log.Println("Binance stream subscription:", err)
+	}
+}
+
+// Disconnected returns for how long the stream has been without a live connection, zero while connected
+func (s *BinanceStream) Disconnected() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.socket != nil {
+		return 0
+	}
+	return time.Since(s.detached)
+}
+
+// Touch records an event of the channel
+func (s *BinanceStream) Touch(channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lastEvents[channel]; ok {
+		s.lastEvents[channel] = time.Now()
+	}
+}
+
+// Stale returns the channels without events for longer than their tier threshold
+func (s *BinanceStream) Stale(tiers collector.StalenessTiers) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return tiers.Stale(s.lastEvents, channelSymbol)
+}
+
+// Resubscribe renews the subscriptions of the channels on the live connection
+func (s *BinanceStream) Resubscribe(channels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.request("UNSUBSCRIBE", channels)
+	s.request("SUBSCRIBE", channels)
+	s.reset(channels)
+}
 
+// Recycle closes the live connection, the stream reconnects with all channels
+func (s *BinanceStream) Recycle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.socket != nil {
+		s.socket.Close()
+	}
+}
+
+// Restarts the staleness period of the channels, must be called with the lock held
+func (s *BinanceStream) reset(channels []string) {
+	now := time.Now()
+	for _, channel := range channels {
+		s.lastEvents[channel] = now
+	}
+}
+
+// Channels are named after the lowercase symbol, e.g. btcusdt@aggTrade
+func channelSymbol(channel string) string {
+	if i := strings.Index(channel, "@"); i >= 0 {
+		channel = channel[:i]
+	}
+	return strings.ToUpper(channel)
+}
+
+func (s *BinanceStream) url(channels []string) string {
 	query := strings.Join(channels, "/")
-	url := fmt.Sprintf("wss://stream.binance.com:9443/stream?streams=%v", query)
+	return fmt.Sprintf("%v/stream?streams=%v", *streamAddr, query)
+}
+
+// Makes the socket available for subscription requests, the channels changed since
+// the connection was dialed are requested on it
+func (s *BinanceStream) attach(ws *websocket.Conn, dialed []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.socket = ws
 
-	events := make(chan StreamEvent)
-	abort := make(chan struct{})
+	subscribed := make(map[string]bool)
+	for _, channel := range dialed {
+		subscribed[channel] = true
+	}
+
+	added := make([]string, 0)
+	for _, channel := range s.channels {
+		if !subscribed[channel] {
+			added = append(added, channel)
+		}
+		delete(subscribed, channel)
+	}
 
-	sockets := make(chan *websocket.Conn)
-	connect := make(chan struct{})
-	stopReadLoop := make(chan struct{}, 1)
+	removed := make([]string, 0, len(subscribed))
+	for channel := range subscribed {
+		removed = append(removed, channel)
+	}
 
-	go func() {
-		defer close(events)
-		defer close(sockets)
-		defer close(connect)
-
-		for {
-			select {
-			case <-connect:
-				binance_websocket_connection_reconnects_total.Inc()
-				sockets <- connectBinanceStream(url)
-			case <-abort:
-				stopReadLoop <- struct{}{}
+	if len(added) > 0 {
+		s.request("SUBSCRIBE", added)
+	}
+	if len(removed) > 0 {
+		s.request("UNSUBSCRIBE", removed)
+	}
+
+	// The time without connection doesn't count towards staleness
+	s.reset(s.channels)
+}
+
+// Closes the socket, subscription requests stay with the replacement of a rotation
+func (s *BinanceStream) detach(ws *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.socket == ws {
+		s.socket = nil
+		s.detached = time.Now()
+	}
+	ws.Close()
+}
+
+// OpenBinanceStream connects to binance public websocket streams until the context is cancelled.
+// The event channels are closed once the frames received before the closing handshake are emitted.
+func OpenBinanceStream(ctx context.Context, channels []string) *BinanceStream {
+	binance_websocket_streams_total.Add(float64(len(channels)))
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	stream := &BinanceStream{
+		cancel:     cancel,
+		events:     make(chan StreamEvent),
+		depth:      make(chan DepthEvent),
+		channels:   channels,
+		lastEvents: make(map[string]time.Time),
+		detached:   time.Now(),
+	}
+
+	// The last seen aggregated trade ids also recover the trades missed while reconnecting
+	stream.frames = &frameHandler{
+		events:       stream.events,
+		depth:        stream.depth,
+		lastTradeIDs: make(map[string]int64),
+	}
+
+	stream.reset(channels)
+
+	go stream.run(ctx)
+
+	return stream
+}
+
+// Websocket connection of the stream read by its own goroutine
+type binanceConn struct {
+	id        int64
+	socket    *websocket.Conn
+	keepalive *collector.Keepalive
+	read      chan struct{}
+
+	// Frames held back while the connection overlaps the one it replaces
+	held    bool
+	pending [][]byte
+}
+
+// Emits the frames of the stream connections. The frames of a replacement are held back until
+// the replaced connection ends, so the trades are emitted in order and the duplicates are dropped.
+type frameHandler struct {
+	mu           sync.Mutex
+	events       chan StreamEvent
+	depth        chan DepthEvent
+	lastTradeIDs map[string]int64
+}
+
+func (h *frameHandler) handle(c *binanceConn, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c.held {
+		c.pending = append(c.pending, data)
+		return
+	}
+
+	handleBinanceFrame(data, h.events, h.depth, h.lastTradeIDs)
+}
+
+// Emits the held frames of the connection, the trades already emitted by the replaced connection are skipped
+func (h *frameHandler) release(c *binanceConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, data := range c.pending {
+		handleBinanceFrame(data, h.events, h.depth, h.lastTradeIDs)
+	}
+
+	c.held = false
+	c.pending = nil
+}
+
+// Forgets the last trade ids of the symbols without a trade channel among the channels,
+// so the unsubscribed symbols are not backfilled
+func (h *frameHandler) prune(channels []string) {
+	subscribed := make(map[string]bool)
+	for _, channel := range channels {
+		if strings.HasSuffix(channel, "@aggTrade") {
+			subscribed[channelSymbol(channel)] = true
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for symbol := range h.lastTradeIDs {
+		if !subscribed[symbol] {
+			delete(h.lastTradeIDs, symbol)
+		}
+	}
+}
+
+// Copies the last trade ids, they may be pruned by an unsubscribe while the copy is backfilled
+func (h *frameHandler) copyTradeIDs() map[string]int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	lastTradeIDs := make(map[string]int64, len(h.lastTradeIDs))
+	for symbol, id := range h.lastTradeIDs {
+		lastTradeIDs[symbol] = id
+	}
+	return lastTradeIDs
+}
+
+// Moves the last trade ids of the symbols still tracked forward to the backfilled ones
+func (h *frameHandler) advance(lastTradeIDs map[string]int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for symbol, id := range lastTradeIDs {
+		if last, ok := h.lastTradeIDs[symbol]; ok && id > last {
+			h.lastTradeIDs[symbol] = id
+		}
+	}
+}
+
+// Recovers the trades of the dialed channels missed while the stream had no connection,
+// the late frames of unsubscribed channels don't get them backfilled
+func (s *BinanceStream) backfill(ctx context.Context, dialed []string) {
+	s.frames.prune(dialed)
+
+	lastTradeIDs := s.frames.copyTradeIDs()
+	backfillBinanceTrades(ctx, *binanceApi, lastTradeIDs, s.events)
+	s.frames.advance(lastTradeIDs)
+}
+
+// Reconnects the stream until the context is cancelled
+func (s *BinanceStream) run(ctx context.Context) {
+	defer close(s.events)
+	defer close(s.depth)
+
+	frames := s.frames
+
+	var conn *binanceConn
+
+	for {
+		if conn == nil {
+			binance_websocket_connection_reconnects_total.Inc()
+			dialed := s.Channels()
+			socket, err := connectBinanceStream(ctx, s.url(dialed))
+			if err != nil {
 				return
 			}
+
+			s.backfill(ctx, dialed)
+			conn = s.open(socket, dialed, frames, false)
 		}
-	}()
 
-	go func() {
-		for socket := range sockets {
-			readBinanceStream(socket, events, stopReadLoop)
-			connect <- struct{}{}
+		conn = s.serve(ctx, conn, frames)
+
+		if conn == nil && ctx.Err() != nil {
+			return
 		}
+	}
+}
+
+// Starts reading the socket, the frames of a held connection are emitted once it is released
+func (s *BinanceStream) open(socket *websocket.Conn, dialed []string, frames *frameHandler, held bool) *binanceConn {
+	c := &binanceConn{
+		id:     collector.NextConnectionID(),
+		socket: socket,
+		read:   make(chan struct{}),
+		held:   held,
+	}
+
+	log.Printf("Binance stream connection %v established", c.id)
+
+	// The deadline starts with the reads, a long backfill must not time out the connection
+	c.keepalive = collector.StartKeepalive(socket, nil)
+	s.attach(socket, dialed)
+
+	go func() {
+		readBinanceStream(socket, c.keepalive, c.id, func(data []byte) {
+			frames.handle(c, data)
+		})
+		c.keepalive.Stop()
+		close(c.read)
 	}()
 
-	connect <- struct{}{}
+	return c
+}
+
+// Waits until the connection ends, returns the replacement once the connection got rotated
+func (s *BinanceStream) serve(ctx context.Context, c *binanceConn, frames *frameHandler) *binanceConn {
+	var rotate <-chan time.Time
+	if *rotateAfter > 0 {
+		timer := time.NewTimer(*rotateAfter)
+		defer timer.Stop()
+		rotate = timer.C
+	}
 
-	return BinanceStream{
-		close:    abort,
-		events:   events,
-		channels: channels,
+	select {
+	case <-c.read:
+		s.detach(c.socket)
+		return nil
+	case <-ctx.Done():
+		// Cancelling starts the closing handshake, the reader emits the frames received until the server confirms
+		c.keepalive.Close()
+		<-c.read
+		s.detach(c.socket)
+		log.Printf("Binance stream connection %v closed", c.id)
+		return nil
+	case <-rotate:
+		return s.rotate(ctx, c, frames)
 	}
 }
 
-func connectBinanceStream(url string) *websocket.Conn {
-	for {
-		log.Println("Connecting to binance stream at:", url)
-		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+// Replaces the connection before Binance drops it after 24 hours. Both connections are read
+// during the overlap, so the replacement holds every trade after the last one of the old connection.
+func (s *BinanceStream) rotate(ctx context.Context, old *binanceConn, frames *frameHandler) *binanceConn {
+	log.Printf("Rotating binance stream connection %v", old.id)
 
-		if err != nil {
-			binance_websocket_connection_errors.Inc()
-			log.Println("Binance stream error:", err)
-			log.Println("Reconnecting to binance stream after 5 seconds")
+	dialed := s.Channels()
+	socket, err := connectBinanceStream(ctx, s.url(dialed))
+	if err != nil {
+		old.keepalive.Close()
+		<-old.read
+		s.detach(old.socket)
+		log.Printf("Binance stream connection %v closed", old.id)
+		return nil
+	}
+
+	// The old connection ended while dialing, recover the gap the same way as after a reconnect
+	select {
+	case <-old.read:
+		s.detach(old.socket)
+		s.backfill(ctx, dialed)
+		return s.open(socket, dialed, frames, false)
+	default:
+	}
+
+	next := s.open(socket, dialed, frames, true)
+	binance_websocket_rotations_total.Inc()
+
+	select {
+	case <-time.After(*rotateOverlap):
+	case <-old.read:
+	case <-ctx.Done():
+	}
+
+	// The old connection emits the frames received until the server confirms the close
+	old.keepalive.Close()
+	<-old.read
+	s.detach(old.socket)
+	frames.release(next)
+
+	log.Printf("Binance stream connection %v replaced by %v", old.id, next.id)
+
+	return next
+}
 
-			<-time.After(5 * time.Second)
+// Dials the stream until it succeeds or the context is cancelled
+func connectBinanceStream(ctx context.Context, url string) (*websocket.Conn, error) {
+	backoff := collector.NewBackoff(exchangeCircuit)
+
+	for attempt := 0; ; attempt++ {
+		if !backoff.Wait(ctx.Done()) {
+			return nil, ctx.Err()
+		}
+
+		// Binance limits the connection attempts per IP
+		if err := binanceLimiter.Connect(ctx); err != nil {
+			return nil, err
+		}
+
+		if attempt > 0 {
 			binance_websocket_connection_reconnects_total.Inc()
-			continue
 		}
 
-		return ws
+		log.Println("Connecting to binance stream at:", url)
+		ws, resp, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+
+		if err == nil {
+			backoff.Success()
+			return ws, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		binance_websocket_connection_errors.Inc()
+		log.Println("Binance stream error:", err)
+		backoff.Failure(resp)
 	}
 }
 
-func readBinanceStream(ws *websocket.Conn, events chan StreamEvent, abort chan struct{}) {
-	// Be polite and perform gracefull disconnection
-	defer ws.WriteMessage(websocket.CloseInternalServerErr, make([]byte, 0))
-	defer ws.Close()
-
+// Reads the frames until the connection ends and passes them to the handler
+func readBinanceStream(ws *websocket.Conn, keepalive *collector.Keepalive, conn int64, handle func(data []byte)) {
 	binance_websocket_connections_open.Inc()
 	defer binance_websocket_connections_open.Dec()
 
 	for {
-		select {
-		case <-abort:
+		_, data, err := ws.ReadMessage()
+
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 			return
-		default:
 		}
 
-		var event StreamEvent
-		err := ws.ReadJSON(&event)
+		if keepalive.TimedOut(err) {
+			binance_websocket_read_timeouts_total.Inc()
+			log.Printf("Binance stream connection %v got no frames within the read timeout, reconnecting", conn)
+		}
 
 		if err != nil {
 			binance_websocket_connection_errors.Inc()
@@ -167,7 +629,59 @@ func readBinanceStream(ws *websocket.Conn, events chan StreamEvent, abort chan s
 			return
 		}
 
+		keepalive.Alive()
+
+		collector.Recorder.Write(conn, time.Now(), data)
 		binance_websocket_streams_events_total.Inc()
+
+		handle(data)
+	}
+}
+
+// Decodes the combined stream frame and emits the trade or depth event
+func handleBinanceFrame(data []byte, events chan StreamEvent, depth chan DepthEvent, lastTradeIDs map[string]int64) {
+	var frame streamFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		log.Println("Binance stream parse:", err)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(frame.Stream, "@aggTrade"):
+		event := StreamEvent{Stream: frame.Stream}
+		if err := json.Unmarshal(frame.Payload, &event.Payload); err != nil {
+			log.Println("Binance stream parse:", err)
+			return
+		}
+		// Skip the trades already emitted by the backfill
+		if last, ok := lastTradeIDs[event.Payload.Symbol]; ok && event.Payload.AggTradeID <= last {
+			return
+		}
+		lastTradeIDs[event.Payload.Symbol] = event.Payload.AggTradeID
 		events <- event
+	case strings.HasSuffix(frame.Stream, DEPTH_STREAM_SUFFIX):
+		event := DepthEvent{Stream: frame.Stream}
+		if err := json.Unmarshal(frame.Payload, &event.Payload); err != nil {
+			log.Println("Binance stream parse:", err)
+			return
+		}
+		depth <- event
+	}
+}
+
+// replayBinanceStream feeds the recorded frames through the stream parser and closes the channels at the end
+func replayBinanceStream(path string, speed float64, events chan StreamEvent, depth chan DepthEvent) {
+	defer close(events)
+	defer close(depth)
+
+	lastTradeIDs := make(map[string]int64)
+
+	err := collector.ReplayCapture(path, speed, func(frame collector.CaptureFrame) {
+		binance_websocket_streams_events_total.Inc()
+		handleBinanceFrame(frame.Data, events, depth, lastTradeIDs)
+	})
+
+	if err != nil {
+		log.Println("Binance replay:", err)
 	}
 }
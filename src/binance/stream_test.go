@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamUnsubscribeForgetsTradeIDs(t *testing.T) {
+	stream := &BinanceStream{
+		channels:   []string{"btcusdt@aggTrade", "ethusdt@aggTrade", "ethusdt@depth@100ms"},
+		lastEvents: make(map[string]time.Time),
+		frames: &frameHandler{lastTradeIDs: map[string]int64{
+			"BTCUSDT": 10,
+			"ETHUSDT": 20,
+		}},
+	}
+
+	stream.Unsubscribe([]string{"ethusdt@aggTrade"})
+
+	if ids := stream.frames.lastTradeIDs; len(ids) != 1 || ids["BTCUSDT"] != 10 {
+		t.Fatalf("got last trade ids %v after the unsubscribe", ids)
+	}
+
+	// The ids of late frames of the unsubscribed channel are dropped before the backfill
+	stream.frames.lastTradeIDs["ETHUSDT"] = 21
+	stream.frames.prune(stream.Channels())
+
+	if _, ok := stream.frames.lastTradeIDs["ETHUSDT"]; ok {
+		t.Errorf("late trade id of the unsubscribed channel kept")
+	}
+}
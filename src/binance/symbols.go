@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"collector"
+)
+
+var refreshInterval = flag.Duration("refresh", 10*time.Minute, "interval of symbol refreshes from the exchange info, 0 disables refreshing")
+
+var (
+	binance_symbols_subscribed = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "binance_symbols_subscribed",
+		Help: "The number of symbols with subscribed trades",
+	})
+	binance_symbols_listed_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_symbols_listed_total",
+		Help: "The total number of symbols subscribed after a refresh",
+	})
+	binance_symbols_delisted_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_symbols_delisted_total",
+		Help: "The total number of symbols unsubscribed after a refresh",
+	})
+	binance_symbols_refresh_errors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "binance_symbols_refresh_errors",
+		Help: "The total number of failed exchange info refreshes",
+	})
+)
+
+// Instruments of the subscribed symbols, safe for concurrent use
+type Instruments struct {
+	mu       sync.RWMutex
+	bySymbol map[string]collector.Instrument
+}
+
+func NewInstruments(symbols []Symbol) *Instruments {
+	instruments := &Instruments{}
+	instruments.Update(symbols)
+	return instruments
+}
+
+// Get returns the instrument of the symbol
+func (i *Instruments) Get(symbol string) collector.Instrument {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.bySymbol[symbol]
+}
+
+// Update replaces the known instruments
+func (i *Instruments) Update(symbols []Symbol) {
+	bySymbol := make(map[string]collector.Instrument)
+	for _, symbol := range symbols {
+		bySymbol[symbol.Symbol] = symbol.Instrument()
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.bySymbol = bySymbol
+}
+
+// refreshSymbols periodically fetches the exchange info and updates the pool subscriptions
+// with the listed and delisted symbols
+func refreshSymbols(ctx context.Context, api string, interval time.Duration, filter *SymbolFilter, symbols []Symbol, pool *BinanceStreamPool, instruments *Instruments) {
+	known := symbolSet(symbols)
+	binance_symbols_subscribed.Set(float64(len(known)))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		info, err := requestExchangeInfo(api)
+		if err != nil {
+			binance_symbols_refresh_errors.Inc()
+			log.Println("Refresh exchanges:", err)
+			continue
+		}
+
+		symbols = filter.Filter(info.Symbols)
+		current := symbolSet(symbols)
+
+		listed := make([]string, 0)
+		for symbol := range current {
+			if !known[symbol] {
+				listed = append(listed, symbol)
+			}
+		}
+
+		delisted := make([]string, 0)
+		for symbol := range known {
+			if !current[symbol] {
+				delisted = append(delisted, symbol)
+			}
+		}
+
+		if len(listed) == 0 && len(delisted) == 0 {
+			continue
+		}
+
+		log.Println("Symbols listed:", listed, "delisted:", delisted)
+		binance_symbols_listed_total.Add(float64(len(listed)))
+		binance_symbols_delisted_total.Add(float64(len(delisted)))
+		binance_symbols_subscribed.Set(float64(len(current)))
+
+		instruments.Update(symbols)
+
+		names := make([]string, 0, len(current))
+		for symbol := range current {
+			names = append(names, symbol)
+		}
+		pool.Update(names)
+
+		known = current
+	}
+}
+
+func symbolSet(symbols []Symbol) map[string]bool {
+	set := make(map[string]bool)
+	for _, symbol := range symbols {
+		set[symbol.Symbol] = true
+	}
+	return set
+}
@@ -1,101 +0,0 @@
-// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
-
-package main
-
-import (
-	context "context"
-	grpc "google.golang.org/grpc"
-	codes "google.golang.org/grpc/codes"
-	status "google.golang.org/grpc/status"
-)
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.32.0 or later.
-const _ = grpc.SupportPackageIsVersion7
-
-// SyncServiceClient is the client API for SyncService service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
-type SyncServiceClient interface {
-	PushTrade(ctx context.Context, in *TradeRequest, opts ...grpc.CallOption) (*Empty, error)
-}
-
-type syncServiceClient struct {
-	cc grpc.ClientConnInterface
-}
-
-func NewSyncServiceClient(cc grpc.ClientConnInterface) SyncServiceClient {
-	return &syncServiceClient{cc}
-}
-
-func (c *syncServiceClient) PushTrade(ctx context.Context, in *TradeRequest, opts ...grpc.CallOption) (*Empty, error) {
-	out := new(Empty)
-	err := c.cc.Invoke(ctx, "/sync.SyncService/PushTrade", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-// SyncServiceServer is the server API for SyncService service.
-// All implementations must embed UnimplementedSyncServiceServer
-// for forward compatibility
-type SyncServiceServer interface {
-	PushTrade(context.Context, *TradeRequest) (*Empty, error)
-	mustEmbedUnimplementedSyncServiceServer()
-}
-
-// UnimplementedSyncServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedSyncServiceServer struct {
-}
-
-func (UnimplementedSyncServiceServer) PushTrade(context.Context, *TradeRequest) (*Empty, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method PushTrade not implemented")
-}
-func (UnimplementedSyncServiceServer) mustEmbedUnimplementedSyncServiceServer() {}
-
-// UnsafeSyncServiceServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to SyncServiceServer will
-// result in compilation errors.
-type UnsafeSyncServiceServer interface {
-	mustEmbedUnimplementedSyncServiceServer()
-}
-
-func RegisterSyncServiceServer(s grpc.ServiceRegistrar, srv SyncServiceServer) {
-	s.RegisterService(&SyncService_ServiceDesc, srv)
-}
-
-func _SyncService_PushTrade_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(TradeRequest)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(SyncServiceServer).PushTrade(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/sync.SyncService/PushTrade",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SyncServiceServer).PushTrade(ctx, req.(*TradeRequest))
-	}
-	return interceptor(ctx, in, info, handler)
-}
-
-// SyncService_ServiceDesc is the grpc.ServiceDesc for SyncService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var SyncService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "sync.SyncService",
-	HandlerType: (*SyncServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "PushTrade",
-			Handler:    _SyncService_PushTrade_Handler,
-		},
-	},
-	Streams:  []grpc.StreamDesc{},
-	Metadata: "sync.proto",
-}
@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var BackoffBase = flag.Duration("backoff-base", time.Second, "delay ceiling of the first retry, doubled after every failed attempt")
+var BackoffMax = flag.Duration("backoff-max", 2*time.Minute, "maximum delay between retries")
+var CircuitFailures = flag.Int("circuit-failures", 10, "failed attempts in a row across all connections which hold back every retry for the maximum delay, 0 disables the circuit")
+
+// Tracks the failures of all retry loops talking to the exchange, shared by all connections to it
+// so a single Retry-After or an outage holds back every retry
+type Circuit struct {
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+	statuses []int
+}
+
+// NewCircuit creates a circuit honoring the Retry-After of the responses with the rate limit statuses of the exchange
+func NewCircuit(statuses ...int) *Circuit {
+	return &Circuit{statuses: statuses}
+}
+
+// Failure counts the failed attempt, too many in a row open the circuit for the maximum delay
+func (c *Circuit) Failure() {
+	c.mu.Lock()
+	c.failures++
+	trip := *CircuitFailures > 0 && c.failures >= *CircuitFailures
+	if trip {
+		c.failures = 0
+	}
+	c.mu.Unlock()
+
+	if trip {
+		log.Printf("Too many failed attempts in a row, holding back retries for %v", *BackoffMax)
+		c.Hold(*BackoffMax)
+	}
+}
+
+// Success closes the circuit
+func (c *Circuit) Success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+}
+
+// Hold keeps the circuit open for at least the duration
+func (c *Circuit) Hold(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(c.until) {
+		c.until = until
+	}
+}
+
+// Observe holds the circuit as long as the rate limited response asks for
+func (c *Circuit) Observe(resp *http.Response) {
+	if d, ok := c.retryAfter(resp); ok {
+		log.Printf("Rate limited with %v, holding back retries for %v", resp.Status, d)
+		c.Hold(d)
+	}
+}
+
+// RateLimited holds back the retries for the base delay, for the exchanges reporting
+// the exceeded rate limit in the body of a successful response
+func (c *Circuit) RateLimited() {
+	log.Printf("Rate limited, holding back retries for %v", *BackoffBase)
+	c.Hold(*BackoffBase)
+}
+
+// Remaining returns the time until the circuit closes
+func (c *Circuit) Remaining() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Until(c.until)
+}
+
+// Exponential backoff with full jitter of a single retry loop
+type Backoff struct {
+	circuit  *Circuit
+	failures int
+}
+
+func NewBackoff(circuit *Circuit) *Backoff {
+	return &Backoff{circuit: circuit}
+}
+
+// Delay returns the wait before the next attempt, the first attempt only waits for an open circuit
+func (b *Backoff) Delay() time.Duration {
+	delay := time.Duration(0)
+	if b.failures > 0 {
+		delay = fullJitter(b.failures - 1)
+	}
+
+	if hold := b.circuit.Remaining(); hold > delay {
+		delay = hold
+	}
+
+	return delay
+}
+
+// Wait sleeps for the delay of the next attempt, returns false when done is closed first
+func (b *Backoff) Wait(done <-chan struct{}) bool {
+	delay := b.Delay()
+	if delay <= 0 {
+		return true
+	}
+
+	log.Printf("Retrying after %v", delay.Round(time.Millisecond))
+
+	select {
+	case <-time.After(delay):
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Failure counts the failed attempt, the response may be nil when the request did not get one
+func (b *Backoff) Failure(resp *http.Response) {
+	b.failures++
+	b.circuit.Failure()
+	b.circuit.Observe(resp)
+}
+
+// Success starts over with the next failure
+func (b *Backoff) Success() {
+	b.failures = 0
+	b.circuit.Success()
+}
+
+// Returns a random delay up to the base doubled by the number of retries, capped by the maximum delay
+func fullJitter(retries int) time.Duration {
+	ceiling := *BackoffMax
+	if retries < 32 {
+		if d := *BackoffBase << uint(retries); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retryAfter returns the wait requested by a response with one of the rate limit statuses
+func (c *Circuit) retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || !c.limited(resp.StatusCode) {
+		return 0, false
+	}
+
+	header := resp.Header.Get("Retry-After")
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date), true
+	}
+
+	return *BackoffMax, true
+}
+
+func (c *Circuit) limited(status int) bool {
+	for _, s := range c.statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Sets the backoff flags for the test and restores them afterwards
+func setBackoff(t *testing.T, base time.Duration, max time.Duration, failures int) {
+	oldBase, oldMax, oldFailures := *BackoffBase, *BackoffMax, *CircuitFailures
+	t.Cleanup(func() {
+		*BackoffBase, *BackoffMax, *CircuitFailures = oldBase, oldMax, oldFailures
+	})
+
+	*BackoffBase, *BackoffMax, *CircuitFailures = base, max, failures
+}
+
+func TestFullJitterBounds(t *testing.T) {
+	setBackoff(t, 100*time.Millisecond, time.Second, 0)
+
+	for retries, ceiling := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := fullJitter(retries); d < 0 || d > ceiling {
+				t.Fatalf("fullJitter(%v) = %v, want at most %v", retries, d, ceiling)
+			}
+		}
+	}
+
+	if d := fullJitter(100); d > time.Second {
+		t.Errorf("fullJitter(100) = %v exceeds the maximum", d)
+	}
+}
+
+func TestBackoffWaitsForCircuit(t *testing.T) {
+	setBackoff(t, time.Millisecond, time.Minute, 0)
+
+	circuit := &Circuit{}
+	backoff := NewBackoff(circuit)
+
+	if d := backoff.Delay(); d != 0 {
+		t.Errorf("first attempt delayed by %v", d)
+	}
+
+	backoff.Failure(nil)
+	if d := backoff.Delay(); d > time.Millisecond {
+		t.Errorf("first retry delayed by %v", d)
+	}
+
+	// Another connection got rate limited
+	circuit.Hold(10 * time.Second)
+
+	backoff.Success()
+	if d := backoff.Delay(); d < 9*time.Second {
+		t.Errorf("attempt delayed by %v while the circuit is open", d)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if backoff.Wait(done) {
+		t.Error("wait not interrupted")
+	}
+}
+
+func TestCircuitOpensAfterFailures(t *testing.T) {
+	setBackoff(t, time.Millisecond, time.Minute, 3)
+
+	circuit := &Circuit{}
+
+	circuit.Failure()
+	circuit.Failure()
+	circuit.Success()
+	circuit.Failure()
+	circuit.Failure()
+
+	if d := circuit.Remaining(); d > 0 {
+		t.Fatalf("circuit open after failures interrupted by a success: %v", d)
+	}
+
+	circuit.Failure()
+
+	if d := circuit.Remaining(); d < 59*time.Second {
+		t.Errorf("circuit open for %v after 3 failures in a row, want the maximum delay", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	setBackoff(t, time.Second, time.Minute, 0)
+
+	response := func(status int, header string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: make(http.Header)}
+		if header != "" {
+			resp.Header.Set("Retry-After", header)
+		}
+		return resp
+	}
+
+	circuit := NewCircuit(http.StatusTooManyRequests)
+
+	for _, test := range []struct {
+		resp  *http.Response
+		wait  time.Duration
+		limit bool
+	}{
+		{nil, 0, false},
+		{response(http.StatusServiceUnavailable, "5"), 0, false},
+		{response(http.StatusTooManyRequests, "5"), 5 * time.Second, true},
+		{response(http.StatusTeapot, "120"), 0, false},
+		{response(http.StatusTooManyRequests, ""), time.Minute, true},
+	} {
+		wait, limit := circuit.retryAfter(test.resp)
+		if wait != test.wait || limit != test.limit {
+			t.Errorf("retryAfter(%v) = %v, %v, want %v, %v", test.resp, wait, limit, test.wait, test.limit)
+		}
+	}
+
+	date := response(http.StatusTooManyRequests, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if wait, _ := circuit.retryAfter(date); wait < 59*time.Minute || wait > time.Hour {
+		t.Errorf("got %v for a Retry-After date an hour ahead", wait)
+	}
+}
@@ -0,0 +1,216 @@
+package collector
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const CAPTURE_FLUSH_INTERVAL = time.Second
+
+var RecordFile = flag.String("record", "", "file to record the raw websocket frames to, empty disables recording")
+var ReplayFile = flag.String("replay", "", "capture file to replay instead of connecting to the exchange")
+var ReplaySpeed = flag.Float64("replay-speed", 1, "replay speed relative to the recording, 0 replays as fast as possible")
+
+// Records the frames of all connections when recording is enabled
+var Recorder *CaptureWriter
+
+var connections int64
+
+// Returns unique id of a new websocket connection
+func NextConnectionID() int64 {
+	return atomic.AddInt64(&connections, 1)
+}
+
+// Raw websocket frame as it was received
+type CaptureFrame struct {
+	Conn     int64
+	Received time.Time
+	Data     []byte
+}
+
+// Writes frames to a gzip compressed capture file. Every frame is stored as receive time in unix
+// nanoseconds, connection id and data length followed by the data. Safe for concurrent use.
+type CaptureWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer
+	flushed time.Time
+}
+
+// CreateCapture creates the capture file, an existing file is replaced
+func CreateCapture(path string) (*CaptureWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Recording websocket frames to:", path)
+
+	return &CaptureWriter{
+		file:    file,
+		gzip:    gzip.NewWriter(file),
+		flushed: time.Now(),
+	}, nil
+}
+
+// Write appends the frame to the capture, nothing is written when recording is disabled
+func (c *CaptureWriter) Write(conn int64, received time.Time, data []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint64(header, uint64(received.UnixNano()))
+	binary.BigEndian.PutUint64(header[8:], uint64(conn))
+	binary.BigEndian.PutUint32(header[16:], uint32(len(data)))
+
+	if _, err := c.gzip.Write(header); err != nil {
+		log.Println("Capture write:", err)
+		return
+	}
+
+	if _, err := c.gzip.Write(data); err != nil {
+		log.Println("Capture write:", err)
+		return
+	}
+
+	// Keep the file readable up to the last second if the process gets killed
+	if time.Since(c.flushed) >= CAPTURE_FLUSH_INTERVAL {
+		c.flushed = time.Now()
+		if err := c.gzip.Flush(); err != nil {
+			log.Println("Capture flush:", err)
+		}
+	}
+}
+
+// Close completes the compressed stream and closes the file
+func (c *CaptureWriter) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.gzip.Close(); err != nil {
+		c.file.Close()
+		return err
+	}
+
+	return c.file.Close()
+}
+
+// Reads frames from a capture file in the order they were recorded
+type CaptureReader struct {
+	file   *os.File
+	gzip   *gzip.Reader
+	reader *bufio.Reader
+}
+
+func OpenCapture(path string) (*CaptureReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &CaptureReader{
+		file:   file,
+		gzip:   reader,
+		reader: bufio.NewReader(reader),
+	}, nil
+}
+
+// Next returns the next frame, io.EOF is returned at the end of the capture
+func (c *CaptureReader) Next() (CaptureFrame, error) {
+	var frame CaptureFrame
+
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return frame, err
+	}
+
+	frame.Received = time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+	frame.Conn = int64(binary.BigEndian.Uint64(header[8:]))
+	frame.Data = make([]byte, binary.BigEndian.Uint32(header[16:]))
+
+	if _, err := io.ReadFull(c.reader, frame.Data); err != nil {
+		return frame, err
+	}
+
+	return frame, nil
+}
+
+func (c *CaptureReader) Close() error {
+	c.gzip.Close()
+	return c.file.Close()
+}
+
+// ReplayCapture passes the recorded frames to the handler with the original delays divided by the speed
+func ReplayCapture(path string, speed float64, handle func(CaptureFrame)) error {
+	capture, err := OpenCapture(path)
+	if err != nil {
+		return err
+	}
+
+	defer capture.Close()
+
+	log.Println("Replaying websocket frames from:", path)
+
+	var first time.Time
+	start := time.Now()
+	frames := 0
+
+	for {
+		frame, err := capture.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The recording process was killed before the capture was closed
+			log.Println("Capture is truncated:", path)
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if frames == 0 {
+			first = frame.Received
+		}
+
+		if speed > 0 {
+			offset := time.Duration(float64(frame.Received.Sub(first)) / speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				<-time.After(wait)
+			}
+		}
+
+		handle(frame)
+		frames++
+	}
+
+	log.Printf("Replayed %v frames in %v", frames, time.Since(start))
+
+	return nil
+}
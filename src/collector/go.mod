@@ -0,0 +1,10 @@
+module collector
+
+go 1.16
+
+require (
+	github.com/gorilla/websocket v1.4.2
+	github.com/prometheus/client_golang v1.10.0
+	google.golang.org/grpc v1.36.1
+	google.golang.org/protobuf v1.26.0
+)
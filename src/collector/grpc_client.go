@@ -0,0 +1,315 @@
+package collector
+
+import (
+	context "context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+var GrpcAddr = flag.String("grpc", "127.0.0.1:50051", "grpc server endpoint")
+var BatchSize = flag.Int("batch-size", 500, "maximum number of trades pushed in a single batch")
+var BatchInterval = flag.Duration("batch-interval", time.Second, "maximum time trades are buffered before pushing")
+
+// Metrics by exchange, registered once as they are global
+var (
+	clientMetricsMu sync.Mutex
+	clientMetrics   = make(map[string]*grpcMetrics)
+)
+
+// Pushes the trades to the aggregator in batches, the batches are spooled while it is unreachable
+type GrpcClient struct {
+	*grpcMetrics
+	channel *grpc.ClientConn
+	client  SyncServiceClient
+	mu      sync.Mutex
+	closed  bool
+	trades  chan *TradeRequest
+	done    chan struct{}
+	spool   *Spool
+}
+
+// Metrics of the clients pushing the trades of an exchange
+type grpcMetrics struct {
+	tradesProcessed   prometheus.Counter
+	tradesSentSuccess prometheus.Counter
+	tradesSentFailed  prometheus.Counter
+	tradesSentQueued  prometheus.Gauge
+	batchesSent       prometheus.Histogram
+	tradesSpooled     prometheus.Counter
+	tradesReplayed    prometheus.Counter
+	tradesDropped     prometheus.Counter
+	spoolSize         prometheus.Gauge
+}
+
+// Close flushes the buffered trades and closes the connection
+func (c *GrpcClient) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.trades)
+	c.mu.Unlock()
+
+	<-c.done
+
+	if c.spool != nil {
+		c.spool.Close()
+	}
+
+	c.channel.Close()
+}
+
+// Push queues the trade to be sent with the next batch, the trades pushed after the close are dropped
+func (c *GrpcClient) Push(trade *TradeRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		c.tradesDropped.Inc()
+		return
+	}
+
+	c.tradesProcessed.Inc()
+	c.tradesSentQueued.Inc()
+	c.trades <- trade
+}
+
+// Collects the queued trades and pushes them once the batch is full or the interval passes
+func (c *GrpcClient) batchTrades() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(*BatchInterval)
+	defer ticker.Stop()
+
+	batch := make([]*TradeRequest, 0, *BatchSize)
+
+	for {
+		select {
+		case trade, ok := <-c.trades:
+			if !ok {
+				c.pushBatch(batch)
+				return
+			}
+
+			batch = append(batch, trade)
+
+			if len(batch) >= *BatchSize {
+				c.pushBatch(batch)
+				batch = make([]*TradeRequest, 0, *BatchSize)
+			}
+		case <-ticker.C:
+			c.replaySpool()
+
+			if len(batch) > 0 {
+				c.pushBatch(batch)
+				batch = make([]*TradeRequest, 0, *BatchSize)
+			}
+		}
+	}
+}
+
+func (c *GrpcClient) pushBatch(batch []*TradeRequest) {
+	if len(batch) == 0 {
+		return
+	}
+
+	c.tradesSentQueued.Sub(float64(len(batch)))
+	keyTrades(batch)
+
+	// Keep the order of the trades while there are spooled batches waiting for replay
+	if c.spool != nil && (!c.spool.Empty() || !c.connected()) {
+		c.spoolBatch(batch)
+		return
+	}
+
+	if err := c.sendBatch(batch); err != nil {
+		log.Println("GRPC push batch:", err)
+		c.tradesSentFailed.Add(float64(len(batch)))
+
+		if c.spool != nil {
+			c.spoolBatch(batch)
+		}
+		return
+	}
+
+	c.tradesSentSuccess.Add(float64(len(batch)))
+}
+
+// Gives the trades without a key one made of the batch and their index, so a batch pushed again
+// after a timeout or replayed from the spool is stored once
+func keyTrades(batch []*TradeRequest) {
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	for i, trade := range batch {
+		if trade.TradeKey == "" {
+			trade.TradeKey = fmt.Sprintf("%v/%v", id, i)
+		}
+	}
+}
+
+func (c *GrpcClient) sendBatch(batch []*TradeRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	timer := prometheus.NewTimer(c.batchesSent)
+	defer timer.ObserveDuration()
+
+	_, err := c.client.PushTradeBatch(ctx, &TradeBatch{Trades: batch})
+
+	return err
+}
+
+func (c *GrpcClient) spoolBatch(batch []*TradeRequest) {
+	err := c.spool.Append(&TradeBatch{Trades: batch})
+	c.spoolSize.Set(float64(c.spool.Size()))
+
+	if err != nil {
+		log.Println("Spool append:", err)
+		c.tradesDropped.Add(float64(len(batch)))
+		return
+	}
+
+	c.tradesSpooled.Add(float64(len(batch)))
+}
+
+// Replays the spooled batches in order until the spool is empty or the push fails
+func (c *GrpcClient) replaySpool() {
+	if c.spool == nil {
+		return
+	}
+
+	defer func() {
+		c.spoolSize.Set(float64(c.spool.Size()))
+	}()
+
+	for i := 0; i < SPOOL_REPLAY_BATCHES && !c.spool.Empty() && c.connected(); i++ {
+		batch, err := c.spool.Peek()
+		if err != nil {
+			log.Println("Spool read:", err)
+			return
+		}
+
+		if err := c.sendBatch(batch.Trades); err != nil {
+			log.Println("GRPC replay batch:", err)
+			return
+		}
+
+		if err := c.spool.Ack(); err != nil {
+			log.Println("Spool ack:", err)
+			return
+		}
+
+		c.tradesReplayed.Add(float64(len(batch.Trades)))
+		c.tradesSentSuccess.Add(float64(len(batch.Trades)))
+	}
+}
+
+// Check reports whether the aggregator is reachable
+func (c *GrpcClient) Check() error {
+	if !c.connected() {
+		return fmt.Errorf("aggregator connection is %v", c.channel.GetState())
+	}
+	return nil
+}
+
+// Reports whether it's worth trying to reach the aggregator
+func (c *GrpcClient) connected() bool {
+	state := c.channel.GetState()
+	return state != connectivity.TransientFailure && state != connectivity.Shutdown
+}
+
+// ConnectGRPC connects to the aggregator, the metrics are named after the exchange
+func ConnectGRPC(exchange string) *GrpcClient {
+	log.Println("Connecting to GRPC server:", *GrpcAddr)
+	conn, err := grpc.Dial(*GrpcAddr, grpc.WithInsecure(), grpc.WithBlock())
+
+	if err != nil {
+		log.Fatalln("GRPC connect:", err)
+	}
+
+	var spool *Spool
+
+	if *SpoolDir != "" {
+		spool, err = OpenSpool(*SpoolDir, *SpoolMaxBytes, *SpoolPolicy)
+		if err != nil {
+			log.Fatalln("Spool open:", err)
+		}
+	}
+
+	client := &GrpcClient{
+		grpcMetrics: metricsOf(exchange),
+		channel:     conn,
+		client:      NewSyncServiceClient(conn),
+		trades:      make(chan *TradeRequest, *BatchSize),
+		done:        make(chan struct{}),
+		spool:       spool,
+	}
+
+	go client.batchTrades()
+
+	return client
+}
+
+// Returns the metrics of the exchange, the metric names are prefixed with it
+func metricsOf(exchange string) *grpcMetrics {
+	clientMetricsMu.Lock()
+	defer clientMetricsMu.Unlock()
+
+	if metrics, ok := clientMetrics[exchange]; ok {
+		return metrics
+	}
+
+	metrics := &grpcMetrics{
+		tradesProcessed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: exchange + "_grpc_processed_trades_total",
+			Help: "The total number of processed trades",
+		}),
+		tradesSentSuccess: promauto.NewCounter(prometheus.CounterOpts{
+			Name: exchange + "_grpc_sent_trades_success",
+			Help: "The total number of trades pushed to the aggregator",
+		}),
+		tradesSentFailed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: exchange + "_grpc_sent_trades_failed",
+			Help: "The total number of trades which failed to push to the aggregator",
+		}),
+		tradesSentQueued: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: exchange + "_processed_trades_queued",
+			Help: "The number of trades waiting for the next batch",
+		}),
+		batchesSent: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name: exchange + "_grpc_batch_duration_seconds",
+			Help: "The duration of trade batch pushes in seconds",
+		}),
+		tradesSpooled: promauto.NewCounter(prometheus.CounterOpts{
+			Name: exchange + "_spool_trades_spooled_total",
+			Help: "The total number of trades written to the spool",
+		}),
+		tradesReplayed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: exchange + "_spool_trades_replayed_total",
+			Help: "The total number of spooled trades pushed to the aggregator",
+		}),
+		tradesDropped: promauto.NewCounter(prometheus.CounterOpts{
+			Name: exchange + "_spool_trades_dropped_total",
+			Help: "The total number of trades dropped because the spool was full or the sink was closed",
+		}),
+		spoolSize: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: exchange + "_spool_size_bytes",
+			Help: "The number of bytes stored in the spool",
+		}),
+	}
+
+	clientMetrics[exchange] = metrics
+
+	return metrics
+}
@@ -0,0 +1,100 @@
+package collector
+
+import (
+	context "context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	grpc "google.golang.org/grpc"
+)
+
+// Fake aggregator recording the pushed trades
+type fakeSyncService struct {
+	UnimplementedSyncServiceServer
+
+	mu     sync.Mutex
+	trades []*TradeRequest
+}
+
+// Starts the fake aggregator on a random port and points the grpc flag at it
+func startFakeSyncService(t *testing.T) *fakeSyncService {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &fakeSyncService{}
+	server := grpc.NewServer()
+	RegisterSyncServiceServer(server, service)
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	oldAddr := *GrpcAddr
+	t.Cleanup(func() { *GrpcAddr = oldAddr })
+	*GrpcAddr = listener.Addr().String()
+
+	return service
+}
+
+func (s *fakeSyncService) PushTradeBatch(ctx context.Context, batch *TradeBatch) (*Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades = append(s.trades, batch.Trades...)
+	return &Empty{}, nil
+}
+
+// Trades returns the trades pushed so far
+func (s *fakeSyncService) Trades() []*TradeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*TradeRequest(nil), s.trades...)
+}
+
+func TestGrpcClientDropsTradesPushedAfterClose(t *testing.T) {
+	service := startFakeSyncService(t)
+
+	oldInterval := *BatchInterval
+	t.Cleanup(func() { *BatchInterval = oldInterval })
+	*BatchInterval = time.Hour
+
+	client := ConnectGRPC("test")
+
+	client.Push(&TradeRequest{Symbol: "BTCUSDT", Price: "1"})
+	client.Push(&TradeRequest{Symbol: "BTCUSDT", Price: "2"})
+
+	// Closing flushes the buffered batch right away
+	client.Close()
+
+	client.Push(&TradeRequest{Symbol: "BTCUSDT", Price: "3"})
+	client.Close()
+
+	if trades := service.Trades(); len(trades) != 2 {
+		t.Errorf("got %v trades, want the 2 pushed before the close", len(trades))
+	}
+}
+
+func TestGrpcClientKeysTrades(t *testing.T) {
+	service := startFakeSyncService(t)
+
+	client := ConnectGRPC("test")
+	client.Push(&TradeRequest{Symbol: "BTCUSDT", Price: "1", TradeKey: "1"})
+	client.Push(&TradeRequest{Symbol: "BTCUSDT", Price: "1"})
+	client.Push(&TradeRequest{Symbol: "BTCUSDT", Price: "1"})
+	client.Close()
+
+	trades := service.Trades()
+	if len(trades) != 3 {
+		t.Fatalf("got %v trades, want 3", len(trades))
+	}
+
+	// Trades keep the key given by the collector, the others get a unique one
+	if trades[0].TradeKey != "1" {
+		t.Errorf("got key %v, want the one of the trade", trades[0].TradeKey)
+	}
+	if trades[1].TradeKey == "" || trades[1].TradeKey == trades[2].TradeKey {
+		t.Errorf("got keys %v and %v, want unique keys", trades[1].TradeKey, trades[2].TradeKey)
+	}
+}
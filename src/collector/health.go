@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ReadyWindow = flag.Duration("ready-window", time.Minute, "maximum time since the last event for the service to be ready")
+var LiveWindow = flag.Duration("live-window", 10*time.Minute, "maximum time since the last websocket frame or pong before the service is reported unhealthy")
+
+// Health of the service served at /healthz and /readyz
+var DefaultHealth = NewHealth()
+
+// Implemented by the components with a state worth reporting by the readiness check
+type HealthChecker interface {
+	Check() error
+}
+
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// Tracks the event flow, the websocket frames and the component checks of the service
+type Health struct {
+	lastEvent int64
+	lastFrame int64
+	started   time.Time
+	mu        sync.Mutex
+	checks    []healthCheck
+}
+
+func NewHealth() *Health {
+	return &Health{started: time.Now()}
+}
+
+// AddCheck adds the named check to the readiness endpoint
+func (h *Health) AddCheck(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+// AddChecker adds the component to the readiness endpoint if it reports its state
+func (h *Health) AddChecker(name string, component interface{}) {
+	if checker, ok := component.(HealthChecker); ok {
+		h.AddCheck(name, checker.Check)
+	}
+}
+
+// Event records that an event was received from the exchange
+func (h *Health) Event() {
+	atomic.StoreInt64(&h.lastEvent, time.Now().UnixNano())
+}
+
+// Frame records that the exchange showed signs of life, quiet markets still send pongs
+func (h *Health) Frame() {
+	atomic.StoreInt64(&h.lastFrame, time.Now().UnixNano())
+}
+
+// Returns the time since the last record or since the start when there was none
+func (h *Health) idle(last *int64) (time.Duration, bool) {
+	at := atomic.LoadInt64(last)
+	if at == 0 {
+		return time.Since(h.started), false
+	}
+	return time.Since(time.Unix(0, at)), true
+}
+
+// ServeLive fails when no websocket frame arrived for too long, so the wedged process gets restarted.
+// Events are not required, the markets of the symbols may be quiet.
+func (h *Health) ServeLive(w http.ResponseWriter, r *http.Request) {
+	if idle, _ := h.idle(&h.lastFrame); idle > *LiveWindow {
+		http.Error(w, fmt.Sprintf("no websocket frames for %v", idle.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// ServeReady fails when any of the checks fails or the events stopped flowing
+func (h *Health) ServeReady(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	checks := append([]healthCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	failures := make([]string, 0)
+
+	for _, check := range checks {
+		if err := check.check(); err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", check.name, err))
+		}
+	}
+
+	if idle, seen := h.idle(&h.lastEvent); !seen {
+		failures = append(failures, "events: none received yet")
+	} else if idle > *ReadyWindow {
+		failures = append(failures, fmt.Sprintf("events: none for %v", idle.Round(time.Second)))
+	}
+
+	if len(failures) > 0 {
+		http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func healthStatus(handler http.HandlerFunc) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/", nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestHealthReadiness(t *testing.T) {
+	h := NewHealth()
+
+	var failure error
+	h.AddCheck("sink", func() error { return failure })
+	h.AddChecker("ignored", struct{}{})
+
+	if code, body := healthStatus(h.ServeReady); code != http.StatusServiceUnavailable || !strings.Contains(body, "none received yet") {
+		t.Errorf("ready before the first event: %v %q", code, body)
+	}
+
+	h.Event()
+
+	if code, body := healthStatus(h.ServeReady); code != http.StatusOK {
+		t.Errorf("not ready after an event: %v %q", code, body)
+	}
+
+	failure = errors.New("aggregator connection is TRANSIENT_FAILURE")
+
+	if code, body := healthStatus(h.ServeReady); code != http.StatusServiceUnavailable || !strings.Contains(body, "sink: aggregator connection") {
+		t.Errorf("ready with a failed check: %v %q", code, body)
+	}
+
+	failure = nil
+	atomic.StoreInt64(&h.lastEvent, time.Now().Add(-2**ReadyWindow).UnixNano())
+
+	if code, body := healthStatus(h.ServeReady); code != http.StatusServiceUnavailable || !strings.Contains(body, "events: none for") {
+		t.Errorf("ready without recent events: %v %q", code, body)
+	}
+}
+
+func TestHealthLiveness(t *testing.T) {
+	h := NewHealth()
+
+	if code, _ := healthStatus(h.ServeLive); code != http.StatusOK {
+		t.Errorf("not live after the start: %v", code)
+	}
+
+	h.started = time.Now().Add(-2 * *LiveWindow)
+	h.Event()
+
+	if code, _ := healthStatus(h.ServeLive); code != http.StatusServiceUnavailable {
+		t.Errorf("live without any frame: %v", code)
+	}
+
+	h.Frame()
+
+	if code, _ := healthStatus(h.ServeLive); code != http.StatusOK {
+		t.Errorf("not live after a frame: %v", code)
+	}
+
+	// Quiet markets without events stay live while the pongs arrive
+	atomic.StoreInt64(&h.lastEvent, time.Now().Add(-2**LiveWindow).UnixNano())
+
+	if code, _ := healthStatus(h.ServeLive); code != http.StatusOK {
+		t.Errorf("not live without events: %v", code)
+	}
+}
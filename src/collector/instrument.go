@@ -0,0 +1,46 @@
+package collector
+
+import "strings"
+
+// Asset codes which differ between exchanges mapped to their common name
+var assetAliases = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+// Exchange independent description of a traded pair
+type Instrument struct {
+	Name  string
+	Base  string
+	Quote string
+}
+
+// NewInstrument creates instrument with canonical BASE-QUOTE name from the exchange asset codes
+func NewInstrument(base string, quote string) Instrument {
+	base = NormalizeAsset(base)
+	quote = NormalizeAsset(quote)
+
+	return Instrument{
+		Name:  base + "-" + quote,
+		Base:  base,
+		Quote: quote,
+	}
+}
+
+// NormalizeAsset returns the common name of the exchange asset code
+func NormalizeAsset(asset string) string {
+	asset = strings.ToUpper(strings.TrimSpace(asset))
+
+	if alias, ok := assetAliases[asset]; ok {
+		return alias
+	}
+
+	return asset
+}
+
+// Apply sets the instrument fields of the trade
+func (i Instrument) Apply(trade *TradeRequest) {
+	trade.Instrument = i.Name
+	trade.BaseAsset = i.Base
+	trade.QuoteAsset = i.Quote
+}
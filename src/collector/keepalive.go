@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ReadTimeout = flag.Duration("read-timeout", time.Minute, "time without any frame from the exchange after which the connection is considered dead and reconnected, 0 disables the deadline")
+var PingInterval = flag.Duration("ping-interval", 20*time.Second, "interval of the pings keeping an idle connection alive, 0 disables the pings")
+
+// Keeps the read deadline of the websocket ahead while the exchange shows signs of life.
+// The pings make sure even an idle connection receives the pongs, so a half-open connection
+// fails the read once the deadline passes instead of hanging forever.
+type Keepalive struct {
+	ws      *websocket.Conn
+	timeout time.Duration
+	mu      sync.Mutex
+	closing bool
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// StartKeepalive sets the first read deadline and starts pinging the exchange.
+// The ping function sends the ping of the exchange protocol, nil sends websocket ping frames.
+func StartKeepalive(ws *websocket.Conn, ping func() error) *Keepalive {
+	k := &Keepalive{ws: ws, timeout: *ReadTimeout, stop: make(chan struct{})}
+
+	if ping == nil {
+		ping = func() error {
+			return ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(CLOSE_GRACE_PERIOD))
+		}
+	}
+
+	ws.SetPongHandler(func(string) error {
+		k.Alive()
+		return nil
+	})
+
+	// Same as the default handler apart from extending the deadline
+	ws.SetPingHandler(func(data string) error {
+		k.Alive()
+		err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	k.extend()
+
+	if *PingInterval > 0 {
+		go k.ping(*PingInterval, ping)
+	}
+
+	return k
+}
+
+// Alive records a frame from the exchange and extends the read deadline
+func (k *Keepalive) Alive() {
+	DefaultHealth.Frame()
+	k.extend()
+}
+
+// Extends the read deadline, the deadline of the closing handshake is kept as is
+func (k *Keepalive) extend() {
+	if k.timeout <= 0 {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if !k.closing {
+		k.ws.SetReadDeadline(time.Now().Add(k.timeout))
+	}
+}
+
+// Stop stops the pings, it doesn't wait for a ping in progress
+func (k *Keepalive) Stop() {
+	k.once.Do(func() {
+		close(k.stop)
+	})
+}
+
+// Close stops the pings and starts the closing handshake
+func (k *Keepalive) Close() {
+	k.Stop()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.closing = true
+	CloseWebsocket(k.ws)
+}
+
+// TimedOut reports whether the read failed because the exchange went silent,
+// a timeout of the closing handshake doesn't count
+func (k *Keepalive) TimedOut(err error) bool {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return !k.closing
+}
+
+func (k *Keepalive) ping(interval time.Duration, ping func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				log.Println("Ping:", err)
+			}
+		case <-k.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"regexp"
+	"strings"
+)
+
+// List of glob patterns or /regular expressions/ matched against symbols or pair names
+type PatternList []func(string) bool
+
+func ParsePatterns(patterns []string) (PatternList, error) {
+	list := make(PatternList, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		expr := ""
+
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		} else {
+			// Glob wildcards match any character including the slash of pair names
+			expr = regexp.QuoteMeta(strings.ToUpper(pattern))
+			expr = strings.ReplaceAll(expr, `\*`, ".*")
+			expr = strings.ReplaceAll(expr, `\?`, ".")
+			expr = "(?i)^" + expr + "$"
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, re.MatchString)
+	}
+
+	return list, nil
+}
+
+// Match reports whether any of the patterns matches the name
+func (l PatternList) Match(name string) bool {
+	for _, match := range l {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitList returns the non-empty items of the comma separated list
+func SplitList(value string) []string {
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,43 @@
+package collector
+
+import "testing"
+
+func TestParsePatterns(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"BTC*", "BTCUSDT", true},
+		{"btc*", "BTCUSDT", true},
+		{"*USDT", "ETHBTC", false},
+		{"ETH?TC", "ETHBTC", true},
+		{"ETH?TC", "ETHBBTC", false},
+		{"XBT/*", "XBT/USD", true},
+		{"*/USD", "XBT/USDT", false},
+		{"BTC.USDT", "BTCXUSDT", false},
+		{"/^ETH(USDT|BTC)$/", "ETHUSDT", true},
+		{"/^ETH(USDT|BTC)$/", "ETHBUSD", false},
+		{"/USD/", "XBT/USDT", true},
+		{"/^eth/", "ETHUSDT", false},
+	} {
+		patterns, err := ParsePatterns([]string{test.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := patterns.Match(test.name); got != test.match {
+			t.Errorf("%v matches %v: got %v, want %v", test.pattern, test.name, got, test.match)
+		}
+	}
+
+	if _, err := ParsePatterns([]string{"/(BTC/"}); err == nil {
+		t.Error("invalid regular expression accepted")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := SplitList(" BTC*, ,*USDT ,"); len(got) != 2 || got[0] != "BTC*" || got[1] != "*USDT" {
+		t.Errorf("got %q", got)
+	}
+}
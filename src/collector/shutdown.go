@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Time the exchange gets to confirm the close frame before the connection is dropped
+const CLOSE_GRACE_PERIOD = 5 * time.Second
+
+var ShutdownTimeout = flag.Duration("shutdown-timeout", 20*time.Second, "maximum time to drain the received trades to the sinks on SIGTERM")
+
+// shutdownSignal returns a channel closed on the first SIGINT or SIGTERM, the second one kills the process
+func ShutdownSignal() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("Received %v, shutting down", sig)
+		close(stop)
+	}()
+
+	return stop
+}
+
+// drain waits for the shutdown step to finish, the step is abandoned once the deadline passes.
+// Returns whether the step finished in time.
+func Drain(deadline time.Time, name string, step func()) bool {
+	done := make(chan struct{})
+
+	go func() {
+		step()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		log.Printf("Shutdown deadline passed while draining %v", name)
+		return false
+	}
+}
+
+// closeWebsocket starts the closing handshake, the reader ends with the close frame of the server
+// or with a timeout after the grace period
+func CloseWebsocket(ws *websocket.Conn) {
+	deadline := time.Now().Add(CLOSE_GRACE_PERIOD)
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+
+	if err := ws.WriteControl(websocket.CloseMessage, message, deadline); err != nil {
+		ws.Close()
+		return
+	}
+
+	ws.UnderlyingConn().SetReadDeadline(deadline)
+}
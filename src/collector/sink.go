@@ -0,0 +1,365 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const SINK_FLUSH_INTERVAL = time.Second
+
+var Sinks = flag.String("sink", "grpc", "comma separated trade sinks: grpc, stdout, json:<file> or csv:<file>")
+var sinkRotate = flag.Duration("sink-rotate", time.Hour, "rotation interval of the file sinks, 0 disables time based rotation")
+var sinkMaxBytes = flag.Int64("sink-max-bytes", 100<<20, "maximum size of a file sink before rotation, 0 disables size based rotation")
+
+// Destination of the collected trades, the trades pushed after the close are dropped
+type TradeSink interface {
+	Push(trade *TradeRequest)
+	Close()
+}
+
+// OpenSinks creates the sinks described by the sink flag, the metrics of the grpc sink are named after the exchange
+func OpenSinks(spec string, exchange string) (TradeSink, error) {
+	fanout := make(FanOutSink, 0)
+
+	for _, item := range SplitList(spec) {
+		kind, path := item, ""
+		if i := strings.Index(item, ":"); i >= 0 {
+			kind, path = item[:i], item[i+1:]
+		}
+
+		switch kind {
+		case "grpc":
+			fanout = append(fanout, ConnectGRPC(exchange))
+		case "stdout":
+			fanout = append(fanout, &JSONSink{writer: newStdoutWriter()})
+		case "json":
+			writer, err := newFileWriter(path, nil)
+			if err != nil {
+				fanout.Close()
+				return nil, err
+			}
+			fanout = append(fanout, &JSONSink{writer: writer})
+		case "csv":
+			writer, err := newFileWriter(path, csvRecord(csvHeader))
+			if err != nil {
+				fanout.Close()
+				return nil, err
+			}
+			fanout = append(fanout, &CSVSink{writer: writer})
+		default:
+			fanout.Close()
+			return nil, fmt.Errorf("unknown sink: %v", item)
+		}
+	}
+
+	if len(fanout) == 0 {
+		return nil, fmt.Errorf("no sinks configured")
+	}
+
+	if len(fanout) == 1 {
+		return fanout[0], nil
+	}
+
+	return fanout, nil
+}
+
+// Pushes every trade to all sinks
+type FanOutSink []TradeSink
+
+func (f FanOutSink) Push(trade *TradeRequest) {
+	for _, sink := range f {
+		sink.Push(trade)
+	}
+}
+
+// Check fails when any of the sinks reporting their state fails
+func (f FanOutSink) Check() error {
+	for _, sink := range f {
+		if checker, ok := sink.(HealthChecker); ok {
+			if err := checker.Check(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f FanOutSink) Close() {
+	for _, sink := range f {
+		sink.Close()
+	}
+}
+
+// Writes trades as newline delimited JSON
+type JSONSink struct {
+	mu     sync.Mutex
+	closed bool
+	writer *recordWriter
+}
+
+func (s *JSONSink) Push(trade *TradeRequest) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(trade)
+	if err != nil {
+		log.Println("JSON sink encode:", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if err := s.writer.Write(append(data, '\n')); err != nil {
+		log.Println("JSON sink write:", err)
+	}
+}
+
+func (s *JSONSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.writer.Close()
+	}
+}
+
+var csvHeader = []string{
+	"symbol",
+	"price",
+	"quantity",
+	"trade_time",
+	"exchange",
+	"trade_id",
+	"first_trade_id",
+	"last_trade_id",
+	"side",
+	"order_type",
+	"event_time",
+	"instrument",
+	"base_asset",
+	"quote_asset",
+}
+
+// Writes trades as CSV with a header at the beginning of every file
+type CSVSink struct {
+	mu     sync.Mutex
+	closed bool
+	writer *recordWriter
+}
+
+func (s *CSVSink) Push(trade *TradeRequest) {
+	record := csvRecord([]string{
+		trade.Symbol,
+		trade.Price,
+		trade.Quantity,
+		strconv.FormatFloat(trade.TradeTime, 'f', -1, 64),
+		trade.Exchange,
+		trade.TradeId,
+		strconv.FormatInt(trade.FirstTradeId, 10),
+		strconv.FormatInt(trade.LastTradeId, 10),
+		enumName(trade.Side.String(), "SIDE_"),
+		enumName(trade.OrderType.String(), "ORDER_TYPE_"),
+		strconv.FormatFloat(trade.EventTime, 'f', -1, 64),
+		trade.Instrument,
+		trade.BaseAsset,
+		trade.QuoteAsset,
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if err := s.writer.Write(record); err != nil {
+		log.Println("CSV sink write:", err)
+	}
+}
+
+func (s *CSVSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.writer.Close()
+	}
+}
+
+func csvRecord(fields []string) []byte {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(fields)
+	w.Flush()
+	return []byte(sb.String())
+}
+
+// Converts enum value name like SIDE_BUY to buy, the unspecified values are left empty
+func enumName(name string, prefix string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, prefix))
+	if name == "unspecified" {
+		return ""
+	}
+	return name
+}
+
+// Buffered writer of records to stdout or a file which is replaced once it gets too old or too big.
+// Every file is named after the configured path with the creation time appended to the name.
+// The buffer is flushed periodically, so the records of a quiet market don't stay buffered.
+type recordWriter struct {
+	mu     sync.Mutex
+	path   string
+	header []byte
+	file   io.WriteCloser
+	buffer *bufio.Writer
+	opened time.Time
+	size   int64
+	done   chan struct{}
+}
+
+func newStdoutWriter() *recordWriter {
+	w := &recordWriter{
+		file:   nopCloser{os.Stdout},
+		buffer: bufio.NewWriter(os.Stdout),
+		done:   make(chan struct{}),
+	}
+
+	go w.flushPeriodically()
+
+	return w
+}
+
+func newFileWriter(path string, header []byte) (*recordWriter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file sink requires a path")
+	}
+
+	w := &recordWriter{path: path, header: header, done: make(chan struct{})}
+
+	if err := w.rotate(); err != nil {
+		return nil, err
+	}
+
+	go w.flushPeriodically()
+
+	return w, nil
+}
+
+func (w *recordWriter) flushPeriodically() {
+	ticker := time.NewTicker(SINK_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if err := w.buffer.Flush(); err != nil {
+				log.Println("Sink flush:", err)
+			}
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *recordWriter) Write(record []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.path != "" && w.due() {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if w.size == 0 && w.header != nil {
+		n, err := w.buffer.Write(w.header)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := w.buffer.Write(record)
+	w.size += int64(n)
+
+	return err
+}
+
+func (w *recordWriter) Close() {
+	close(w.done)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.buffer.Flush(); err != nil {
+		log.Println("Sink flush:", err)
+	}
+	w.file.Close()
+}
+
+// Reports whether the file should be rotated before the next write
+func (w *recordWriter) due() bool {
+	if *sinkRotate > 0 && time.Since(w.opened) >= *sinkRotate {
+		return true
+	}
+	return *sinkMaxBytes > 0 && w.size >= *sinkMaxBytes
+}
+
+// Opens the next file before closing the current one, so a failed rotation leaves the current file open
+func (w *recordWriter) rotate() error {
+	ext := filepath.Ext(w.path)
+	name := fmt.Sprintf("%v-%v%v", strings.TrimSuffix(w.path, ext), time.Now().UTC().Format("20060102T150405.000"), ext)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Writing trades to:", name)
+
+	if w.file != nil {
+		if err := w.buffer.Flush(); err != nil {
+			log.Println("Sink flush:", err)
+		}
+		if err := w.file.Close(); err != nil {
+			log.Println("Sink close:", err)
+		}
+	}
+
+	w.file = file
+	w.buffer = bufio.NewWriter(file)
+	w.opened = time.Now()
+	w.size = 0
+
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,206 @@
+package collector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sinkTrade(price string) *TradeRequest {
+	return &TradeRequest{
+		Symbol:     "BTCUSDT",
+		Price:      price,
+		Quantity:   "0.5",
+		TradeTime:  1600000000.25,
+		Exchange:   "binance",
+		TradeId:    "42",
+		Side:       Side_SIDE_BUY,
+		OrderType:  OrderType_ORDER_TYPE_MARKET,
+		Instrument: "BTC-USDT",
+		BaseAsset:  "BTC",
+		QuoteAsset: "USDT",
+	}
+}
+
+// Returns the contents of the files written for the sink path in creation order
+func sinkFiles(t *testing.T, path string) []string {
+	t.Helper()
+
+	ext := filepath.Ext(path)
+	names, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+
+	contents := make([]string, 0, len(names))
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+
+	return contents
+}
+
+func TestJSONSinkEncodesTrades(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trades.json")
+
+	writer, err := newFileWriter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &JSONSink{writer: writer}
+	sink.Push(sinkTrade("1"))
+	sink.Push(sinkTrade("2"))
+	sink.Close()
+
+	files := sinkFiles(t, path)
+	if len(files) != 1 {
+		t.Fatalf("got %v files, want 1", len(files))
+	}
+
+	lines := strings.Split(strings.TrimSuffix(files[0], "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got lines %q, want 2", lines)
+	}
+
+	var trade map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &trade); err != nil {
+		t.Fatal(err)
+	}
+
+	for field, want := range map[string]interface{}{
+		"price":      "2",
+		"trade_time": 1600000000.25,
+		"trade_id":   "42",
+		"side":       "SIDE_BUY",
+		"instrument": "BTC-USDT",
+	} {
+		if trade[field] != want {
+			t.Errorf("got %v %v, want %v", field, trade[field], want)
+		}
+	}
+}
+
+func TestCSVSinkEncodesTrades(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trades.csv")
+
+	writer, err := newFileWriter(path, csvRecord(csvHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &CSVSink{writer: writer}
+	sink.Push(sinkTrade("1"))
+	sink.Close()
+
+	want := strings.Join(csvHeader, ",") + "\n" +
+		"BTCUSDT,1,0.5,1600000000.25,binance,42,0,0,buy,market,0,BTC-USDT,BTC,USDT\n"
+
+	if files := sinkFiles(t, path); len(files) != 1 || files[0] != want {
+		t.Errorf("got files %q, want %q", files, want)
+	}
+}
+
+func TestFileSinkRotatesBySize(t *testing.T) {
+	oldMaxBytes := *sinkMaxBytes
+	t.Cleanup(func() { *sinkMaxBytes = oldMaxBytes })
+	*sinkMaxBytes = 1
+
+	path := filepath.Join(t.TempDir(), "trades.csv")
+
+	writer, err := newFileWriter(path, csvRecord(csvHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &CSVSink{writer: writer}
+	for _, price := range []string{"1", "2", "3"} {
+		// The files are named after their creation time in milliseconds
+		time.Sleep(2 * time.Millisecond)
+		sink.Push(sinkTrade(price))
+	}
+	sink.Close()
+
+	files := sinkFiles(t, path)
+	if len(files) != 3 {
+		t.Fatalf("got %v files, want one per trade", len(files))
+	}
+
+	for i, file := range files {
+		lines := strings.Split(strings.TrimSuffix(file, "\n"), "\n")
+		if len(lines) != 2 || lines[0] != strings.Join(csvHeader, ",") {
+			t.Errorf("file %v is not a header and a trade: %q", i, lines)
+		}
+	}
+}
+
+func TestFileSinkFlushesWhileIdle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trades.json")
+
+	writer, err := newFileWriter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &JSONSink{writer: writer}
+	defer sink.Close()
+
+	sink.Push(sinkTrade("1"))
+
+	deadline := time.Now().Add(SINK_FLUSH_INTERVAL + 2*time.Second)
+	for files := sinkFiles(t, path); len(files) != 1 || files[0] == ""; files = sinkFiles(t, path) {
+		if time.Now().After(deadline) {
+			t.Fatal("trade still buffered after the flush interval")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Sink recording the pushed trades
+type recordingSink struct {
+	trades []*TradeRequest
+	closed bool
+}
+
+func (s *recordingSink) Push(trade *TradeRequest) {
+	s.trades = append(s.trades, trade)
+}
+
+func (s *recordingSink) Close() {
+	s.closed = true
+}
+
+func TestFanOutSinkPushesToAllSinks(t *testing.T) {
+	first, second := &recordingSink{}, &recordingSink{}
+	fanout := FanOutSink{first, second}
+
+	fanout.Push(sinkTrade("1"))
+	fanout.Push(sinkTrade("2"))
+	fanout.Close()
+
+	for i, sink := range []*recordingSink{first, second} {
+		if len(sink.trades) != 2 || sink.trades[1].Price != "2" {
+			t.Errorf("sink %v got trades %v", i, sink.trades)
+		}
+		if !sink.closed {
+			t.Errorf("sink %v not closed", i)
+		}
+	}
+}
+
+func TestOpenSinksRejectsUnknownSinks(t *testing.T) {
+	for _, spec := range []string{"", "kafka", "json:"} {
+		if _, err := OpenSinks(spec, "test"); err == nil {
+			t.Errorf("OpenSinks(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package collector
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const SPOOL_SEGMENT_SIZE = 4 << 20
+const SPOOL_REPLAY_BATCHES = 20
+const SPOOL_OFFSET_FILE = "spool.offset"
+
+const (
+	SPOOL_DROP_OLDEST = "drop-oldest"
+	SPOOL_DROP_NEWEST = "drop-newest"
+)
+
+var SpoolDir = flag.String("spool", "", "directory for spooling trades while the aggregator is unreachable, empty disables spooling")
+var SpoolMaxBytes = flag.Int64("spool-max-bytes", 1<<30, "maximum size of the spool on disk")
+var SpoolPolicy = flag.String("spool-policy", SPOOL_DROP_OLDEST, "what to drop when the spool is full (drop-oldest or drop-newest)")
+
+// Returned by Append when the spool is full and the policy is drop-newest
+var ErrSpoolFull = errors.New("spool is full")
+
+type spoolSegment struct {
+	path string
+	size int64
+}
+
+// Write-ahead spool of trade batches stored as length prefixed records in segment files.
+// Batches are replayed in the order they were appended, the replay offset is persisted on every ack
+// so a restart resumes after the last acked batch. The spool is not safe for concurrent use.
+type Spool struct {
+	dir      string
+	maxBytes int64
+	policy   string
+	segments []spoolSegment
+	sequence int
+	size     int64
+	head     *os.File
+	tail     *os.File
+	offset   int64
+	next     int64
+}
+
+// OpenSpool opens the spool in the given directory and loads the segments left from previous runs
+func OpenSpool(dir string, maxBytes int64, policy string) (*Spool, error) {
+	if policy != SPOOL_DROP_OLDEST && policy != SPOOL_DROP_NEWEST {
+		return nil, fmt.Errorf("unknown spool policy: %v", policy)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "spool-*.dat"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+
+	spool := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		policy:   policy,
+		segments: make([]spoolSegment, 0, len(paths)),
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var sequence int
+		fmt.Sscanf(filepath.Base(path), "spool-%d.dat", &sequence)
+		if sequence > spool.sequence {
+			spool.sequence = sequence
+		}
+
+		spool.segments = append(spool.segments, spoolSegment{path: path, size: info.Size()})
+		spool.size += info.Size()
+	}
+
+	if len(paths) > 0 {
+		spool.offset = spool.loadOffset()
+		log.Printf("Spool %v contains %v bytes from previous runs, resuming at offset %v", dir, spool.size, spool.offset)
+	}
+
+	return spool, nil
+}
+
+// Empty reports whether there are batches waiting for replay
+func (s *Spool) Empty() bool {
+	for i, segment := range s.segments {
+		if i > 0 || s.offset < segment.size {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the number of bytes stored in the spool
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// Append persists the batch at the end of the spool
+func (s *Spool) Append(batch *TradeBatch) error {
+	data, err := proto.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	record := int64(len(data) + 4)
+
+	for s.size+record > s.maxBytes && len(s.segments) > 0 {
+		if s.policy == SPOOL_DROP_NEWEST {
+			return ErrSpoolFull
+		}
+		log.Println("Spool is full, dropping segment:", s.segments[0].path)
+		if err := s.removeTail(); err != nil {
+			return err
+		}
+	}
+
+	if s.head == nil || s.segments[len(s.segments)-1].size >= SPOOL_SEGMENT_SIZE {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buffer := make([]byte, record)
+	binary.BigEndian.PutUint32(buffer, uint32(len(data)))
+	copy(buffer[4:], data)
+
+	if _, err := s.head.Write(buffer); err != nil {
+		return err
+	}
+
+	if err := s.head.Sync(); err != nil {
+		return err
+	}
+
+	s.segments[len(s.segments)-1].size += record
+	s.size += record
+
+	return nil
+}
+
+// Peek returns the oldest batch in the spool without removing it
+func (s *Spool) Peek() (*TradeBatch, error) {
+	for !s.Empty() {
+		if s.tail == nil {
+			tail, err := os.Open(s.segments[0].path)
+			if err != nil {
+				return nil, err
+			}
+			s.tail = tail
+		}
+
+		header := make([]byte, 4)
+		_, err := s.tail.ReadAt(header, s.offset)
+
+		var data []byte
+		if err == nil {
+			// A corrupted header must not allocate more than the segment holds
+			length := int64(binary.BigEndian.Uint32(header))
+			if s.offset+4+length > s.segments[0].size {
+				err = io.EOF
+			} else {
+				data = make([]byte, length)
+				_, err = s.tail.ReadAt(data, s.offset+4)
+			}
+		}
+
+		if err == io.EOF {
+			// Segment was truncated by a crash or the header is corrupted, skip the rest of the segment
+			log.Println("Spool segment is truncated:", s.segments[0].path)
+			s.offset = s.segments[0].size
+			if err := s.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.next = s.offset + int64(len(data)) + 4
+
+		var batch TradeBatch
+		if err := proto.Unmarshal(data, &batch); err != nil {
+			log.Println("Spool record is corrupted:", err)
+			if err := s.Ack(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return &batch, nil
+	}
+
+	return nil, io.EOF
+}
+
+// Ack removes the batch returned by the last Peek from the spool
+func (s *Spool) Ack() error {
+	s.offset = s.next
+	if err := s.advance(); err != nil {
+		return err
+	}
+	return s.saveOffset()
+}
+
+// Close closes the open segment files, the spooled batches remain on disk
+func (s *Spool) Close() error {
+	if s.tail != nil {
+		s.tail.Close()
+		s.tail = nil
+	}
+
+	if s.head != nil {
+		return s.head.Close()
+	}
+
+	return nil
+}
+
+// Removes the oldest segment once all of its records are replayed
+func (s *Spool) advance() error {
+	if len(s.segments) == 0 || s.offset < s.segments[0].size {
+		return nil
+	}
+
+	return s.removeTail()
+}
+
+func (s *Spool) removeTail() error {
+	if s.tail != nil {
+		s.tail.Close()
+		s.tail = nil
+	}
+
+	if len(s.segments) == 1 && s.head != nil {
+		s.head.Close()
+		s.head = nil
+	}
+
+	if err := os.Remove(s.segments[0].path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.size -= s.segments[0].size
+	s.segments = s.segments[1:]
+	s.offset = 0
+	s.next = 0
+
+	return s.saveOffset()
+}
+
+// Persists the replay offset of the oldest segment. The offset file is replaced by a rename, a crash
+// may lose the latest offset though, the batches replayed again are deduplicated by their trade keys.
+func (s *Spool) saveOffset() error {
+	path := filepath.Join(s.dir, SPOOL_OFFSET_FILE)
+
+	// A new segment may get the name of a removed one, the offset must not outlive its segment
+	if len(s.segments) == 0 || s.offset == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data := fmt.Sprintf("%v %v\n", filepath.Base(s.segments[0].path), s.offset)
+	if err := ioutil.WriteFile(path+".tmp", []byte(data), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// Returns the persisted replay offset of the oldest segment, zero if it belongs to another segment
+func (s *Spool) loadOffset() int64 {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, SPOOL_OFFSET_FILE))
+	if err != nil {
+		return 0
+	}
+
+	var name string
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%s %d", &name, &offset); err != nil {
+		log.Println("Spool offset is corrupted:", err)
+		return 0
+	}
+
+	if name != filepath.Base(s.segments[0].path) || offset < 0 || offset > s.segments[0].size {
+		return 0
+	}
+
+	return offset
+}
+
+func (s *Spool) rotate() error {
+	if s.head != nil {
+		if err := s.head.Close(); err != nil {
+			return err
+		}
+	}
+
+	s.sequence++
+	path := filepath.Join(s.dir, fmt.Sprintf("spool-%020d.dat", s.sequence))
+
+	head, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.head = head
+	s.segments = append(s.segments, spoolSegment{path: path})
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func spoolBatch(symbol string) *TradeBatch {
+	return &TradeBatch{Trades: []*TradeRequest{{Symbol: symbol, Price: "1", Quantity: "1"}}}
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 1<<20, SPOOL_DROP_OLDEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		if err := spool.Append(spoolBatch(symbol)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		batch, err := spool.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := batch.Trades[0].Symbol; got != symbol {
+			t.Fatalf("got batch of %v, want %v", got, symbol)
+		}
+		if err := spool.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := spool.Peek(); err != io.EOF || !spool.Empty() || spool.Size() != 0 {
+		t.Errorf("got %v from the replayed spool of %v bytes", err, spool.Size())
+	}
+}
+
+func TestSpoolResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(dir, 1<<20, SPOOL_DROP_OLDEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT", "XRPUSDT"} {
+		if err := spool.Append(spoolBatch(symbol)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := spool.Peek(); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Ack(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crashed while replaying the second batch
+	if _, err := spool.Peek(); err != nil {
+		t.Fatal(err)
+	}
+	spool.Close()
+
+	spool, err = OpenSpool(dir, 1<<20, SPOOL_DROP_OLDEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	for _, symbol := range []string{"ETHUSDT", "XRPUSDT"} {
+		batch, err := spool.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := batch.Trades[0].Symbol; got != symbol {
+			t.Fatalf("got batch of %v, want %v", got, symbol)
+		}
+		if err := spool.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The replayed segment is removed along with its offset, a new segment starts at its beginning
+	if _, err := os.Stat(filepath.Join(dir, SPOOL_OFFSET_FILE)); !os.IsNotExist(err) {
+		t.Errorf("offset file outlived the replayed spool: %v", err)
+	}
+}
+
+func TestSpoolSkipsCorruptedLength(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 1<<20, SPOOL_DROP_OLDEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	spool.Append(spoolBatch("BTCUSDT"))
+	spool.Append(spoolBatch("ETHUSDT"))
+
+	// The length prefix of the first record claims far more than the segment holds
+	segment, err := os.OpenFile(spool.segments[0].path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segment.WriteAt([]byte{0xff, 0xff, 0xff, 0xf0}, 0)
+	segment.Close()
+
+	if _, err := spool.Peek(); err != io.EOF {
+		t.Fatalf("got %v reading the corrupted segment, want it skipped", err)
+	}
+
+	// The spool keeps working with a new segment
+	spool.Append(spoolBatch("XRPUSDT"))
+
+	batch, err := spool.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := batch.Trades[0].Symbol; got != "XRPUSDT" {
+		t.Errorf("got batch of %v, want XRPUSDT", got)
+	}
+}
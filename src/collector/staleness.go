@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const STALENESS_CHECK_INTERVAL = 30 * time.Second
+
+type stalenessTier struct {
+	patterns  PatternList
+	threshold time.Duration
+}
+
+// Staleness thresholds by liquidity of the symbols or pairs, e.g. "BTC*=1m,*USDT=10m,*=1h"
+type StalenessTiers []stalenessTier
+
+func ParseStalenessTiers(spec string) (StalenessTiers, error) {
+	tiers := make(StalenessTiers, 0)
+
+	for _, item := range SplitList(spec) {
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("staleness tier without threshold: %v", item)
+		}
+
+		patterns, err := ParsePatterns([]string{item[:i]})
+		if err != nil {
+			return nil, err
+		}
+
+		threshold, err := time.ParseDuration(item[i+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if threshold <= 0 {
+			return nil, fmt.Errorf("staleness threshold must be positive: %v", item)
+		}
+
+		tiers = append(tiers, stalenessTier{patterns: patterns, threshold: threshold})
+	}
+
+	return tiers, nil
+}
+
+// Threshold returns the threshold of the first matching tier, zero means the name is never stale
+func (t StalenessTiers) Threshold(name string) time.Duration {
+	for _, tier := range t {
+		if tier.patterns.Match(name) {
+			return tier.threshold
+		}
+	}
+	return 0
+}
+
+// Stale returns the keys of the last event times older than the threshold of the name of the key
+func (t StalenessTiers) Stale(lastEvents map[string]time.Time, name func(string) string) []string {
+	stale := make([]string, 0)
+
+	for key, last := range lastEvents {
+		threshold := t.Threshold(name(key))
+		if threshold > 0 && time.Since(last) > threshold {
+			stale = append(stale, key)
+		}
+	}
+
+	sort.Strings(stale)
+
+	return stale
+}
@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 
-package main
+package collector
 
 import (
 	context "context"
@@ -19,6 +19,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type SyncServiceClient interface {
 	PushTrade(ctx context.Context, in *TradeRequest, opts ...grpc.CallOption) (*Empty, error)
+	PushTradeBatch(ctx context.Context, in *TradeBatch, opts ...grpc.CallOption) (*Empty, error)
 }
 
 type syncServiceClient struct {
@@ -38,11 +39,21 @@ func (c *syncServiceClient) PushTrade(ctx context.Context, in *TradeRequest, opt
 	return out, nil
 }
 
+func (c *syncServiceClient) PushTradeBatch(ctx context.Context, in *TradeBatch, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/sync.SyncService/PushTradeBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SyncServiceServer is the server API for SyncService service.
 // All implementations must embed UnimplementedSyncServiceServer
 // for forward compatibility
 type SyncServiceServer interface {
 	PushTrade(context.Context, *TradeRequest) (*Empty, error)
+	PushTradeBatch(context.Context, *TradeBatch) (*Empty, error)
 	mustEmbedUnimplementedSyncServiceServer()
 }
 
@@ -53,6 +64,9 @@ type UnimplementedSyncServiceServer struct {
 func (UnimplementedSyncServiceServer) PushTrade(context.Context, *TradeRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PushTrade not implemented")
 }
+func (UnimplementedSyncServiceServer) PushTradeBatch(context.Context, *TradeBatch) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushTradeBatch not implemented")
+}
 func (UnimplementedSyncServiceServer) mustEmbedUnimplementedSyncServiceServer() {}
 
 // UnsafeSyncServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -84,6 +98,24 @@ func _SyncService_PushTrade_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SyncService_PushTradeBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TradeBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SyncServiceServer).PushTradeBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sync.SyncService/PushTradeBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SyncServiceServer).PushTradeBatch(ctx, req.(*TradeBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // SyncService_ServiceDesc is the grpc.ServiceDesc for SyncService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -95,6 +127,10 @@ var SyncService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "PushTrade",
 			Handler:    _SyncService_PushTrade_Handler,
 		},
+		{
+			MethodName: "PushTradeBatch",
+			Handler:    _SyncService_PushTradeBatch_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "sync.proto",
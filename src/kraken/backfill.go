@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"collector"
+)
+
+const BACKFILL_MAX_PAGES = 10
+const BACKFILL_PAGE_LIMIT = 1000
+
+var backfillWorkers = flag.Int("backfill-workers", 4, "pairs recovered concurrently after a reconnect")
+var backfillRate = flag.Float64("backfill-rate", 1, "requests per second to the public Trades endpoint while recovering, 0 disables the limit")
+
+// Trades closer than this are considered to happen at the same time
+const TRADE_TIME_EPSILON = 1e-6
+
+// Number of trades received from a single source at the same time by price and quantity
+type tradeCounts struct {
+	time   float64
+	counts map[string]int
+}
+
+// Counts the trade and returns the number of trades like it at its time
+func (c *tradeCounts) add(trade *collector.TradeRequest) int {
+	if c.counts == nil || math.Abs(trade.TradeTime-c.time) > TRADE_TIME_EPSILON {
+		c.time = trade.TradeTime
+		c.counts = make(map[string]int)
+	}
+
+	key := tradeKey(trade)
+	c.counts[key]++
+
+	return c.counts[key]
+}
+
+func tradeKey(trade *collector.TradeRequest) string {
+	return trade.Price + "/" + trade.Quantity
+}
+
+// Trade state of a single pair. The trades at the last time are counted, so the trades received
+// from both the backfill and the websocket are pushed once while identical fills are all pushed.
+type pairTrades struct {
+	last    float64
+	pushed  map[string]int
+	live    tradeCounts
+	pending []*collector.TradeRequest
+	// Latest recovery holding back the live trades, zero when the pair is live
+	recovery int
+}
+
+func newPairTrades() *pairTrades {
+	return &pairTrades{pushed: make(map[string]int)}
+}
+
+// Tracks the last pushed trade of every pair so the trades missed while reconnecting
+// can be recovered without pushing duplicates
+type TradeTracker struct {
+	mu         sync.Mutex
+	pairs      map[string]*pairTrades
+	recoveries int
+}
+
+func NewTradeTracker() *TradeTracker {
+	return &TradeTracker{
+		pairs: make(map[string]*pairTrades),
+	}
+}
+
+// Live filters the trades received from the websocket and returns the ones ready to push.
+// Trades of pairs being recovered are held back until the backfill completes.
+func (t *TradeTracker) Live(trades []*collector.TradeRequest) []*collector.TradeRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	accepted := make([]*collector.TradeRequest, 0, len(trades))
+
+	for _, trade := range trades {
+		pair := t.pair(trade.Symbol)
+		if pair.recovery != 0 {
+			pair.pending = append(pair.pending, trade)
+			continue
+		}
+		if pair.accept(trade, &pair.live) {
+			accepted = append(accepted, trade)
+		}
+	}
+
+	return accepted
+}
+
+// StartRecovery holds back the live trades of all known pairs until the returned recovery completes
+// and returns their last trade time. A recovery started by another reconnect in the meantime
+// keeps holding back the pairs until it completes as well.
+func (t *TradeTracker) StartRecovery() (int, map[string]float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recoveries++
+	gaps := make(map[string]float64)
+
+	for symbol, pair := range t.pairs {
+		pair.recovery = t.recoveries
+		gaps[symbol] = pair.last
+	}
+
+	return t.recoveries, gaps
+}
+
+// Recover returns the backfilled trades without duplicates, followed by the held back live trades
+// of the pair unless a later recovery still has to backfill the pair
+func (t *TradeTracker) Recover(symbol string, recovery int, backfill []*collector.TradeRequest) []*collector.TradeRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pair := t.pair(symbol)
+	accepted := make([]*collector.TradeRequest, 0, len(backfill)+len(pair.pending))
+
+	var backfilled tradeCounts
+	for _, trade := range backfill {
+		if pair.accept(trade, &backfilled) {
+			accepted = append(accepted, trade)
+		}
+	}
+
+	if pair.recovery != recovery {
+		return accepted
+	}
+
+	for _, trade := range pair.pending {
+		if pair.accept(trade, &pair.live) {
+			accepted = append(accepted, trade)
+		}
+	}
+
+	pair.pending = nil
+	pair.recovery = 0
+
+	return accepted
+}
+
+func (t *TradeTracker) pair(symbol string) *pairTrades {
+	pair, ok := t.pairs[symbol]
+	if !ok {
+		pair = newPairTrades()
+		t.pairs[symbol] = pair
+	}
+	return pair
+}
+
+// Reports whether the trade was not pushed yet and marks it as pushed. The trades of a single source
+// are counted by price and quantity, a trade is new once its source has more of them than were pushed.
+func (p *pairTrades) accept(trade *collector.TradeRequest, source *tradeCounts) bool {
+	switch {
+	case trade.TradeTime < p.last-TRADE_TIME_EPSILON:
+		return false
+	case math.Abs(trade.TradeTime-p.last) <= TRADE_TIME_EPSILON:
+	default:
+		p.last = trade.TradeTime
+		p.pushed = make(map[string]int)
+	}
+
+	key := tradeKey(trade)
+	count := source.add(trade)
+
+	if count <= p.pushed[key] {
+		return false
+	}
+
+	p.pushed[key] = count
+
+	// Kraken trades carry no id on the websocket, the count makes the key of identical fills unique
+	// while the websocket and the backfill derive the same key for the same trade
+	trade.TradeKey = fmt.Sprintf("%v/%v/%v", strconv.FormatFloat(trade.TradeTime, 'f', 6, 64), key, count)
+
+	return true
+}
+
+// backfillTrades recovers the trades missed since the given time of every pair from the REST api.
+// The pairs are fetched by a few workers sharing the request rate, every pair is released as soon
+// as its own backfill completes. Once done is closed the pairs left are released without recovery,
+// so their held back live trades are still pushed.
+func backfillTrades(done <-chan struct{}, recovery int, gaps map[string]float64, pairs *Pairs, tracker *TradeTracker, sink collector.TradeSink) {
+	log.Printf("Recovering trades of %v pairs.", len(gaps))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	concurrency := *backfillWorkers
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	limiter := NewRequestLimiter(*backfillRate, concurrency)
+	symbols := make(chan string)
+
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for symbol := range symbols {
+				backfillPair(ctx, limiter, recovery, symbol, gaps[symbol], pairs, tracker, sink)
+			}
+		}()
+	}
+
+	for symbol := range gaps {
+		symbols <- symbol
+	}
+
+	close(symbols)
+	workers.Wait()
+}
+
+// Recovers the trades of a single pair and pushes them followed by its held back live trades
+func backfillPair(ctx context.Context, limiter *RequestLimiter, recovery int, symbol string, gap float64, pairs *Pairs, tracker *TradeTracker, sink collector.TradeSink) {
+	pair := pairs.Get(symbol)
+	trades := make([]*collector.TradeRequest, 0)
+
+	if pair.Wsname == "" {
+		// The pair was removed by a refresh in the meantime
+		tracker.Recover(symbol, recovery, nil)
+		return
+	}
+
+	// Step back a little so the trades at the boundary are not lost to rounding,
+	// the following pages continue from the cursor returned by Kraken
+	since := strconv.FormatInt(int64((gap-TRADE_TIME_EPSILON)*1e9), 10)
+
+	for page := 0; page < BACKFILL_MAX_PAGES && limiter.Wait(ctx) == nil; page++ {
+		fetched, last, err := fetchRestTrades(ctx, pair.Altname, symbol, since)
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err != nil {
+			log.Printf("Could not recover %v trades: %v", symbol, err)
+			break
+		}
+
+		trades = append(trades, fetched...)
+
+		if len(fetched) < BACKFILL_PAGE_LIMIT || last == "" || last == since {
+			break
+		}
+
+		since = last
+	}
+
+	for _, trade := range tracker.Recover(symbol, recovery, trades) {
+		pair.Instrument().Apply(trade)
+		sink.Push(trade)
+	}
+}
+
+// Spaces the requests evenly at the rate, bursts up to the given number of requests are let through
+type RequestLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	next     time.Time
+}
+
+func NewRequestLimiter(rate float64, burst int) *RequestLimiter {
+	limiter := &RequestLimiter{burst: burst}
+	if rate > 0 {
+		limiter.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return limiter
+}
+
+// Wait blocks until the next request may be sent, returns the error of the context when it is done first
+func (l *RequestLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+
+	// Unused requests only accumulate up to the burst
+	if earliest := now.Add(-time.Duration(l.burst-1) * l.interval); l.next.Before(earliest) {
+		l.next = earliest
+	}
+
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Fetches the trades of the pair after the since cursor from the public Trades endpoint,
+// returns the cursor of the next page
+func fetchRestTrades(ctx context.Context, altname string, symbol string, since string) ([]*collector.TradeRequest, string, error) {
+	url := fmt.Sprintf("%v/0/public/Trades?pair=%v&since=%v", *krakenApi, altname, since)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		exchangeCircuit.Observe(resp)
+		return nil, "", errors.New(resp.Status)
+	}
+
+	var response struct {
+		Error  []string                   `json:"error"`
+		Result map[string]json.RawMessage `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, "", err
+	}
+
+	if len(response.Error) > 0 {
+		observeErrors(exchangeCircuit, response.Error)
+		return nil, "", fmt.Errorf("%v", strings.Join(response.Error, ", "))
+	}
+
+	trades := make([]*collector.TradeRequest, 0)
+	last := ""
+
+	for key, raw := range response.Result {
+		if key == "last" {
+			// The cursor is the nanosecond timestamp to pass as since to get the next page
+			last = strings.Trim(string(raw), `"`)
+			continue
+		}
+
+		var rows [][]interface{}
+		if err := json.Unmarshal(raw, &rows); err != nil {
+			return nil, "", err
+		}
+
+		for _, row := range rows {
+			if len(row) < 3 {
+				return nil, "", fmt.Errorf("invalid trade: %v", row)
+			}
+
+			price, ok1 := row[0].(string)
+			volume, ok2 := row[1].(string)
+			tradeTime, ok3 := row[2].(float64)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, "", fmt.Errorf("invalid trade: %v", row)
+			}
+
+			trade := &collector.TradeRequest{
+				Price:     price,
+				Quantity:  volume,
+				TradeTime: tradeTime,
+				Symbol:    symbol,
+				Exchange:  "kraken",
+			}
+
+			if len(row) > 4 {
+				side, _ := row[3].(string)
+				orderType, _ := row[4].(string)
+				trade.Side = parseSide(side)
+				trade.OrderType = parseOrderType(orderType)
+			}
+
+			// Newer api versions include the trade id
+			if len(row) > 6 {
+				if id, ok := row[6].(float64); ok {
+					trade.TradeId = strconv.FormatInt(int64(id), 10)
+				}
+			}
+
+			trades = append(trades, trade)
+		}
+	}
+
+	return trades, last, nil
+}
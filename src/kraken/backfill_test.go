@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"collector"
+)
+
+func trackedTrade(symbol string, price string, quantity string, tradeTime float64) *collector.TradeRequest {
+	return &collector.TradeRequest{Symbol: symbol, Price: price, Quantity: quantity, TradeTime: tradeTime}
+}
+
+func tradePrices(trades []*collector.TradeRequest) []string {
+	result := make([]string, 0, len(trades))
+	for _, trade := range trades {
+		result = append(result, trade.Price)
+	}
+	return result
+}
+
+func assertTrades(t *testing.T, got []*collector.TradeRequest, want ...string) {
+	t.Helper()
+
+	if p := tradePrices(got); len(p) != len(want) {
+		t.Fatalf("got trades %v, want %v", p, want)
+	} else {
+		for i := range want {
+			if p[i] != want[i] {
+				t.Fatalf("got trades %v, want %v", p, want)
+			}
+		}
+	}
+}
+
+func TestPairTradesAccept(t *testing.T) {
+	pair := newPairTrades()
+	backfill := &tradeCounts{}
+
+	for _, test := range []struct {
+		trade  *collector.TradeRequest
+		source *tradeCounts
+		want   bool
+	}{
+		{trackedTrade("XBT/USD", "100", "1", 10), &pair.live, true},
+		// Identical fills at the same time are all pushed
+		{trackedTrade("XBT/USD", "100", "1", 10), &pair.live, true},
+		// The other source delivers the same trades, only the ones beyond the pushed count are new
+		{trackedTrade("XBT/USD", "100", "1", 10), backfill, false},
+		{trackedTrade("XBT/USD", "100", "1", 10+TRADE_TIME_EPSILON/2), backfill, false},
+		{trackedTrade("XBT/USD", "100", "1", 10), backfill, true},
+		{trackedTrade("XBT/USD", "100", "1", 10), &pair.live, false},
+		// Another trade at the same time differs in price or quantity
+		{trackedTrade("XBT/USD", "100", "2", 10), &pair.live, true},
+		{trackedTrade("XBT/USD", "101", "1", 10), backfill, true},
+		{trackedTrade("XBT/USD", "99", "1", 9), &pair.live, false},
+		{trackedTrade("XBT/USD", "100", "1", 11), &pair.live, true},
+		// The counts of the previous time are forgotten
+		{trackedTrade("XBT/USD", "100", "2", 10), &pair.live, false},
+		{trackedTrade("XBT/USD", "100", "1", 11), backfill, false},
+		{trackedTrade("XBT/USD", "100", "2", 11), backfill, true},
+	} {
+		if got := pair.accept(test.trade, test.source); got != test.want {
+			t.Errorf("accept(%v at %v) = %v, want %v", tradeKey(test.trade), test.trade.TradeTime, got, test.want)
+		}
+	}
+}
+
+func TestTradeTrackerRecover(t *testing.T) {
+	tracker := NewTradeTracker()
+
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{
+		trackedTrade("XBT/USD", "1", "1", 1),
+		trackedTrade("ETH/USD", "2", "1", 1),
+	}), "1", "2")
+
+	recovery, gaps := tracker.StartRecovery()
+	if len(gaps) != 2 || gaps["XBT/USD"] != 1 || gaps["ETH/USD"] != 1 {
+		t.Fatalf("got gaps %v", gaps)
+	}
+
+	// Held back while recovering, the live trades overlap the backfill
+	live := tracker.Live([]*collector.TradeRequest{
+		trackedTrade("XBT/USD", "4", "1", 4),
+		trackedTrade("XBT/USD", "5", "1", 5),
+		trackedTrade("ETH/USD", "6", "1", 6),
+	})
+	if len(live) != 0 {
+		t.Fatalf("got live trades %v while recovering", tradePrices(live))
+	}
+
+	recovered := tracker.Recover("XBT/USD", recovery, []*collector.TradeRequest{
+		trackedTrade("XBT/USD", "1", "1", 1),
+		trackedTrade("XBT/USD", "3", "1", 3),
+		trackedTrade("XBT/USD", "4", "1", 4),
+	})
+	assertTrades(t, recovered, "3", "4", "5")
+
+	// The recovered pair is live again while the other one is still held back
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{
+		trackedTrade("XBT/USD", "7", "1", 7),
+		trackedTrade("ETH/USD", "8", "1", 8),
+	}), "7")
+
+	assertTrades(t, tracker.Recover("ETH/USD", recovery, nil), "6", "8")
+
+	// Another fill identical to the last one
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{trackedTrade("ETH/USD", "8", "1", 8)}), "8")
+}
+
+func TestTradeTrackerOverlappingRecoveries(t *testing.T) {
+	tracker := NewTradeTracker()
+
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{trackedTrade("XBT/USD", "1", "1", 1)}), "1")
+
+	first, _ := tracker.StartRecovery()
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{trackedTrade("XBT/USD", "3", "1", 3)}))
+
+	// Reconnected again before the backfill of the first reconnect completed
+	second, gaps := tracker.StartRecovery()
+	if gaps["XBT/USD"] != 1 {
+		t.Fatalf("got gap from %v, want 1", gaps["XBT/USD"])
+	}
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{trackedTrade("XBT/USD", "6", "1", 6)}))
+
+	// The first backfill ends before the second gap, the pair stays held back for the second one
+	assertTrades(t, tracker.Recover("XBT/USD", first, []*collector.TradeRequest{
+		trackedTrade("XBT/USD", "2", "1", 2),
+		trackedTrade("XBT/USD", "3", "1", 3),
+	}), "2", "3")
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{trackedTrade("XBT/USD", "7", "1", 7)}))
+
+	// The trades missed during the second gap are not dropped as older than the held back live trades
+	assertTrades(t, tracker.Recover("XBT/USD", second, []*collector.TradeRequest{
+		trackedTrade("XBT/USD", "2", "1", 2),
+		trackedTrade("XBT/USD", "3", "1", 3),
+		trackedTrade("XBT/USD", "4", "1", 4),
+		trackedTrade("XBT/USD", "5", "1", 5),
+		trackedTrade("XBT/USD", "6", "1", 6),
+	}), "4", "5", "6", "7")
+
+	assertTrades(t, tracker.Live([]*collector.TradeRequest{trackedTrade("XBT/USD", "8", "1", 8)}), "8")
+}
+
+func TestTradeTrackerKeys(t *testing.T) {
+	live := NewTradeTracker().Live([]*collector.TradeRequest{
+		trackedTrade("XBT/USD", "100.0", "1.0", 10.0000001),
+		trackedTrade("XBT/USD", "100.0", "1.0", 10.0000001),
+		trackedTrade("XBT/USD", "101.0", "1.0", 10.0000001),
+	})
+
+	// After a restart the trades are fetched again with the time as reported by the REST api
+	backfill := NewTradeTracker().Recover("XBT/USD", 0, []*collector.TradeRequest{
+		trackedTrade("XBT/USD", "100.0", "1.0", 10),
+		trackedTrade("XBT/USD", "100.0", "1.0", 10),
+		trackedTrade("XBT/USD", "101.0", "1.0", 10),
+	})
+
+	want := []string{"10.000000/100.0/1.0/1", "10.000000/100.0/1.0/2", "10.000000/101.0/1.0/1"}
+
+	for _, trades := range [][]*collector.TradeRequest{live, backfill} {
+		if len(trades) != len(want) {
+			t.Fatalf("got %v trades, want %v", len(trades), len(want))
+		}
+		for i, trade := range trades {
+			if trade.TradeKey != want[i] {
+				t.Errorf("got key %v, want %v", trade.TradeKey, want[i])
+			}
+		}
+	}
+}
+
+func TestRequestLimiterBurst(t *testing.T) {
+	limiter := NewRequestLimiter(10, 3)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.Wait(ctx)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("burst of 3 took %v", d)
+	}
+
+	limiter.Wait(ctx)
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("request after the burst sent after %v, want the interval", d)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter.Wait(cancelled); err != context.Canceled {
+		t.Errorf("got %v waiting with a cancelled context", err)
+	}
+}
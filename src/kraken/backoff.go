@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"collector"
+)
+
+// Error reported in the body of the REST responses once the call rate limit is exceeded
+const RATE_LIMIT_ERROR = "EAPI:Rate limit exceeded"
+
+// Shared by all connections to Kraken, the CDN in front of it answers 429 to the websocket
+// handshakes and REST calls of clients connecting too often
+var exchangeCircuit = collector.NewCircuit(http.StatusTooManyRequests)
+
+// observeErrors holds back the retries when the errors of the REST response report the exceeded rate limit,
+// Kraken answers those with 200 OK and the call counter decays within seconds
+func observeErrors(circuit *collector.Circuit, errors []string) {
+	for _, err := range errors {
+		if err == RATE_LIMIT_ERROR {
+			circuit.RateLimited()
+			return
+		}
+	}
+}
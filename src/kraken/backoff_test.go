@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"collector"
+)
+
+func TestObserveErrors(t *testing.T) {
+	circuit := collector.NewCircuit()
+
+	observeErrors(circuit, []string{"EQuery:Unknown asset pair"})
+	if wait := circuit.Remaining(); wait > 0 {
+		t.Errorf("circuit held for %v by an unrelated error", wait)
+	}
+
+	observeErrors(circuit, []string{RATE_LIMIT_ERROR})
+	if wait := circuit.Remaining(); wait <= 0 || wait > *collector.BackoffBase {
+		t.Errorf("circuit held for %v by the rate limit error, want up to %v", wait, *collector.BackoffBase)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Number of levels per side included in the Kraken book checksum
+const CHECKSUM_DEPTH = 10
+
+var ErrChecksumMismatch = errors.New("book checksum mismatch")
+
+// Single price level of the order book
+type BookLevel struct {
+	Price  string
+	Volume string
+}
+
+// Local copy of the Kraken order book for a single pair
+type OrderBook struct {
+	mu    sync.RWMutex
+	pair  string
+	depth int
+	bids  map[string]string
+	asks  map[string]string
+}
+
+// Top returns up to n best bids and asks
+func (b *OrderBook) Top(n int) ([]BookLevel, []BookLevel) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return sortLevels(b.bids, n, true), sortLevels(b.asks, n, false)
+}
+
+// Checksum calculates the CRC32 checksum of the book as described by Kraken
+func (b *OrderBook) Checksum() uint32 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var sb strings.Builder
+
+	for _, level := range sortLevels(b.asks, CHECKSUM_DEPTH, false) {
+		sb.WriteString(checksumValue(level.Price))
+		sb.WriteString(checksumValue(level.Volume))
+	}
+
+	for _, level := range sortLevels(b.bids, CHECKSUM_DEPTH, true) {
+		sb.WriteString(checksumValue(level.Price))
+		sb.WriteString(checksumValue(level.Volume))
+	}
+
+	return crc32.ChecksumIEEE([]byte(sb.String()))
+}
+
+func (b *OrderBook) apply(side map[string]string, levels []BookLevel) {
+	for _, level := range levels {
+		if v, err := strconv.ParseFloat(level.Volume, 64); err == nil && v == 0 {
+			delete(side, level.Price)
+		} else {
+			side[level.Price] = level.Volume
+		}
+	}
+}
+
+// Removes the levels outside of the subscribed depth
+func (b *OrderBook) truncate() {
+	for _, side := range []struct {
+		levels     map[string]string
+		descending bool
+	}{{b.bids, true}, {b.asks, false}} {
+		if len(side.levels) <= b.depth {
+			continue
+		}
+		for _, level := range sortLevels(side.levels, 0, side.descending)[b.depth:] {
+			delete(side.levels, level.Price)
+		}
+	}
+}
+
+// Maintains local order books of all subscribed pairs
+type OrderBooks struct {
+	mu    sync.RWMutex
+	depth int
+	books map[string]*OrderBook
+}
+
+func NewOrderBooks(depth int) *OrderBooks {
+	return &OrderBooks{
+		depth: depth,
+		books: make(map[string]*OrderBook),
+	}
+}
+
+// Book returns the order book of the pair if it is available
+func (b *OrderBooks) Book(pair string) (*OrderBook, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	book, ok := b.books[pair]
+	return book, ok
+}
+
+// Reset drops the local book of the pair until a new snapshot is received
+func (b *OrderBooks) Reset(pair string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.books, pair)
+}
+
+// Handle applies book snapshot or update message.
+// Returns ErrChecksumMismatch when the local book diverged from the exchange.
+func (b *OrderBooks) Handle(message *BookMessage) error {
+	if message.Snapshot {
+		b.snapshot(message)
+		return nil
+	}
+
+	return b.update(message)
+}
+
+func (b *OrderBooks) snapshot(message *BookMessage) {
+	book := &OrderBook{
+		pair:  message.Pair,
+		depth: b.depth,
+		bids:  make(map[string]string),
+		asks:  make(map[string]string),
+	}
+
+	book.apply(book.asks, message.Asks)
+	book.apply(book.bids, message.Bids)
+
+	b.mu.Lock()
+	b.books[message.Pair] = book
+	b.mu.Unlock()
+}
+
+func (b *OrderBooks) update(message *BookMessage) error {
+	book, ok := b.Book(message.Pair)
+	if !ok {
+		// Waiting for the snapshot after resubscription
+		return nil
+	}
+
+	book.mu.Lock()
+	book.apply(book.asks, message.Asks)
+	book.apply(book.bids, message.Bids)
+	book.truncate()
+	book.mu.Unlock()
+
+	if message.Checksum == "" {
+		return nil
+	}
+
+	expected, err := strconv.ParseUint(message.Checksum, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid book checksum: %v", message.Checksum)
+	}
+
+	if book.Checksum() != uint32(expected) {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+func sortLevels(side map[string]string, n int, descending bool) []BookLevel {
+	type level struct {
+		price float64
+		BookLevel
+	}
+
+	levels := make([]level, 0, len(side))
+	for price, volume := range side {
+		p, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			continue
+		}
+		levels = append(levels, level{p, BookLevel{Price: price, Volume: volume}})
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		if descending {
+			return levels[i].price > levels[j].price
+		}
+		return levels[i].price < levels[j].price
+	})
+
+	if n > 0 && len(levels) > n {
+		levels = levels[:n]
+	}
+
+	result := make([]BookLevel, len(levels))
+	for i, l := range levels {
+		result[i] = l.BookLevel
+	}
+
+	return result
+}
+
+// Formats the value for the checksum by removing the decimal point and leading zeros
+func checksumValue(value string) string {
+	return strings.TrimLeft(strings.Replace(value, ".", "", 1), "0")
+}
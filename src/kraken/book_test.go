@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func bookLevels(volume string, prices ...string) []BookLevel {
+	levels := make([]BookLevel, 0, len(prices))
+	for _, price := range prices {
+		levels = append(levels, BookLevel{Price: price, Volume: volume})
+	}
+	return levels
+}
+
+func TestChecksumValue(t *testing.T) {
+	for value, want := range map[string]string{
+		"0.05005":    "5005",
+		"0.00000500": "500",
+		"5541.30000": "554130000",
+		"2.50700000": "250700000",
+	} {
+		if got := checksumValue(value); got != want {
+			t.Errorf("checksumValue(%v) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+// The snapshot and its checksum are the example of the Kraken book checksum documentation
+func TestOrderBookChecksum(t *testing.T) {
+	books := NewOrderBooks(CHECKSUM_DEPTH)
+
+	books.Handle(&BookMessage{
+		Pair:     "ETH/XBT",
+		Snapshot: true,
+		Asks:     bookLevels("0.00000500", "0.05005", "0.05010", "0.05015", "0.05020", "0.05025", "0.05030", "0.05035", "0.05040", "0.05045", "0.05050"),
+		Bids:     bookLevels("0.00000500", "0.05000", "0.04995", "0.04990", "0.04980", "0.04975", "0.04970", "0.04965", "0.04960", "0.04955", "0.04950"),
+	})
+
+	book, ok := books.Book("ETH/XBT")
+	if !ok {
+		t.Fatal("no book after the snapshot")
+	}
+	if got := book.Checksum(); got != 974947235 {
+		t.Fatalf("got snapshot checksum %v, want 974947235", got)
+	}
+
+	for _, test := range []struct {
+		name     string
+		update   BookMessage
+		checksum string
+	}{
+		{"volume changed", BookMessage{Asks: bookLevels("0.00000400", "0.05005")}, "90024921"},
+		// The new best bid pushes the worst one out of the subscribed depth
+		{"level inserted", BookMessage{Bids: bookLevels("0.00000100", "0.05001")}, "2758235683"},
+		{"level removed", BookMessage{Asks: bookLevels("0.00000000", "0.05005")}, "3735827122"},
+	} {
+		test.update.Pair = "ETH/XBT"
+		test.update.Checksum = test.checksum
+		if err := books.Handle(&test.update); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+	}
+
+	bids, asks := book.Top(CHECKSUM_DEPTH)
+	if len(bids) != 10 || bids[0].Price != "0.05001" || bids[9].Price != "0.04955" || len(asks) != 9 || asks[0].Price != "0.05010" {
+		t.Errorf("got bids %v and asks %v", bids, asks)
+	}
+
+	err := books.Handle(&BookMessage{Pair: "ETH/XBT", Asks: bookLevels("0.00000100", "0.05005"), Checksum: "3735827122"})
+	if err != ErrChecksumMismatch {
+		t.Errorf("got %v for a diverged book, want the checksum mismatch", err)
+	}
+}
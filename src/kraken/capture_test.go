@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"collector"
+)
+
+// Sink recording the pushed trades
+type recordingSink struct {
+	trades []*collector.TradeRequest
+}
+
+func (s *recordingSink) Push(trade *collector.TradeRequest) {
+	s.trades = append(s.trades, trade)
+}
+
+func (s *recordingSink) Close() {}
+
+// Writes the frames to a new capture file and returns its path
+func writeCapture(t *testing.T, frames ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "frames.capture")
+
+	capture, err := collector.CreateCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := time.Now()
+	for i, frame := range frames {
+		capture.Write(1, received.Add(time.Duration(i)*time.Millisecond), []byte(frame))
+	}
+
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReplayCapturedFrames(t *testing.T) {
+	exchange := newFakeKraken(t, fakePair("XBT", "USD"))
+	*krakenApi = exchange.URL
+
+	filter, err := NewPairFilter(FilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeCapture(t,
+		`{"event":"systemStatus","status":"online","version":"1.0.0"}`,
+		`{"channelID":0,"channelName":"trade","event":"subscriptionStatus","pair":"XBT/USD","status":"subscribed","subscription":{"name":"trade"}}`,
+		`[0,[["5541.20000","0.15850568","1534614057.321597","s","l",""],["6060.00000","0.02455000","1534614057.324998","b","m",""]],"trade","XBT/USD"]`,
+		`{"event":"heartbeat"}`,
+		// Truncated frame, quarantined without stopping the replay
+		`[0,{"a":["5541.30000","2.50700000","1534614248.456738"]},"book-10"`,
+		`[0,[["6061.00000","0.10000000","1534614058.000000","b","m",""]],"trade","XBT/USD"]`,
+	)
+
+	oldSpeed := *collector.ReplaySpeed
+	t.Cleanup(func() { *collector.ReplaySpeed = oldSpeed })
+	*collector.ReplaySpeed = 0
+
+	sink := &recordingSink{}
+	replay(path, filter, sink)
+
+	if len(sink.trades) != 3 {
+		t.Fatalf("got %v trades, want 3", len(sink.trades))
+	}
+
+	sell := sink.trades[0]
+	if sell.Price != "5541.20000" || sell.Quantity != "0.15850568" || sell.Side != collector.Side_SIDE_SELL || sell.OrderType != collector.OrderType_ORDER_TYPE_LIMIT {
+		t.Errorf("unexpected trade: %v", sell)
+	}
+	if sell.Instrument != "BTC-USD" || sell.BaseAsset != "BTC" || sell.QuoteAsset != "USD" {
+		t.Errorf("instrument not resolved: %v", sell)
+	}
+	if last := sink.trades[2]; last.Price != "6061.00000" || last.TradeTime != 1534614058 {
+		t.Errorf("unexpected trade: %v", last)
+	}
+}
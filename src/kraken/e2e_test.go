@@ -0,0 +1,309 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"collector"
+)
+
+const e2eTimeout = 10 * time.Second
+
+// Collector running against the fake exchange and aggregator
+type e2eHarness struct {
+	exchange *fakeKraken
+	service  *fakeSyncService
+	socket   *KrakenSocket
+	sink     collector.TradeSink
+	fetched  chan struct{}
+}
+
+// Starts fetching trades of all pairs of a new fake exchange.
+// The socket is closed before the fake exchange, a reconnect would end up at the exchange
+// of the next test as the websocket address is global.
+func startHarness(t *testing.T, pairs ...Pair) *e2eHarness {
+	exchange := newFakeKraken(t, pairs...)
+	service, grpcAddr := startFakeSyncService(t)
+
+	*krakenApi = exchange.URL
+	*addr = exchange.WebsocketURL()
+	*collector.GrpcAddr = grpcAddr
+	*collector.BatchInterval = 50 * time.Millisecond
+	*bookDepth = 0
+
+	filter, err := NewPairFilter(FilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscribed := NewPairs(loadPairs(filter))
+	sink := collector.ConnectGRPC("kraken")
+
+	h := &e2eHarness{
+		exchange: exchange,
+		service:  service,
+		socket:   &KrakenSocket{},
+		sink:     sink,
+		fetched:  make(chan struct{}),
+	}
+
+	t.Cleanup(func() {
+		h.socket.Close()
+		select {
+		case <-h.fetched:
+		case <-time.After(e2eTimeout):
+			t.Error("timed out waiting for the fetch loop to end")
+		}
+	})
+
+	go func() {
+		fetchTrades(subscribed, h.socket, sink, NewOrderBooks(*bookDepth), NewTradeTracker())
+		close(h.fetched)
+	}()
+
+	h.waitFor(t, "subscription", func() bool { return len(exchange.Subscriptions()) > 0 })
+
+	return h
+}
+
+// Polls the condition until it holds or the test times out
+func (h *e2eHarness) waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(e2eTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		<-time.After(10 * time.Millisecond)
+	}
+}
+
+// Waits until the aggregator receives n trades and returns their prices sorted
+func (h *e2eHarness) waitTrades(t *testing.T, n int) []string {
+	t.Helper()
+
+	h.waitFor(t, "trades", func() bool { return len(h.service.Trades()) >= n })
+
+	prices := make([]string, 0, n)
+	for _, trade := range h.service.Trades() {
+		prices = append(prices, trade.Price)
+	}
+
+	sort.Strings(prices)
+
+	return prices
+}
+
+func trade(price string, offset float64) fakeTrade {
+	return fakeTrade{
+		Price:  price,
+		Volume: "0.5",
+		Time:   1600000000 + offset,
+		Side:   "b",
+		Type:   "m",
+	}
+}
+
+func assertPrices(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got trades %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got trades %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFetchTradesForwardsTrades(t *testing.T) {
+	h := startHarness(t, fakePair("XBT", "USD"), fakePair("ETH", "EUR"))
+
+	subscription := h.exchange.Subscriptions()[0]
+	sort.Strings(subscription.Pair)
+	if subscription.Event != "subscribe" || len(subscription.Pair) != 2 || subscription.Pair[0] != "ETH/EUR" {
+		t.Fatalf("unexpected subscription: %+v", subscription)
+	}
+
+	sell := trade("3000.1", 1)
+	sell.Side = "s"
+	sell.Type = "l"
+
+	h.exchange.Heartbeat()
+	h.exchange.Trade("XBT/USD", trade("50000.5", 0))
+	h.exchange.Trade("ETH/EUR", sell)
+
+	h.waitTrades(t, 2)
+
+	trades := h.service.Trades()
+	sort.Slice(trades, func(i, j int) bool { return trades[i].TradeTime < trades[j].TradeTime })
+
+	if trades[0].Instrument != "BTC-USD" || trades[0].Side != collector.Side_SIDE_BUY || trades[0].OrderType != collector.OrderType_ORDER_TYPE_MARKET {
+		t.Errorf("unexpected trade: %v", trades[0])
+	}
+	if trades[1].Instrument != "ETH-EUR" || trades[1].Side != collector.Side_SIDE_SELL || trades[1].TradeTime != 1600000001 {
+		t.Errorf("unexpected trade: %v", trades[1])
+	}
+}
+
+func TestFetchTradesRecoversAfterReconnect(t *testing.T) {
+	h := startHarness(t, fakePair("XBT", "USD"))
+
+	h.exchange.Trade("XBT/USD", trade("1", 1))
+	h.waitTrades(t, 1)
+
+	h.exchange.Drop()
+
+	// Sent around the reconnect, delivered either by the websocket or by the backfill
+	h.exchange.Trade("XBT/USD", trade("2", 2))
+	h.waitFor(t, "resubscription", func() bool { return len(h.exchange.Subscriptions()) == 2 })
+	h.exchange.Trade("XBT/USD", trade("3", 3))
+
+	h.waitTrades(t, 3)
+
+	// Give possible duplicates a chance to arrive
+	<-time.After(200 * time.Millisecond)
+
+	assertPrices(t, h.waitTrades(t, 3), "1", "2", "3")
+
+	if n := h.exchange.Connections(); n != 2 {
+		t.Errorf("got %v connections, want 2", n)
+	}
+}
+
+func TestFetchTradesSkipsMalformedMessages(t *testing.T) {
+	h := startHarness(t, fakePair("XBT", "USD"))
+
+	h.exchange.Trade("XBT/USD", trade("1", 1))
+	h.exchange.SendRaw([]byte(`[100,[["1.0"`))
+	h.exchange.SendRaw([]byte(`[100,[["1.0",0.5]],"trade","XBT/USD"]`))
+	h.exchange.Trade("XBT/USD", trade("2", 2))
+
+	assertPrices(t, h.waitTrades(t, 2), "1", "2")
+
+	if n := h.exchange.Connections(); n != 1 {
+		t.Errorf("malformed messages caused %v connections", n)
+	}
+}
+
+func TestFetchTradesReconnectsSilentConnection(t *testing.T) {
+	oldTimeout, oldInterval := *collector.ReadTimeout, *collector.PingInterval
+	t.Cleanup(func() { *collector.ReadTimeout, *collector.PingInterval = oldTimeout, oldInterval })
+	*collector.ReadTimeout, *collector.PingInterval = 300*time.Millisecond, 50*time.Millisecond
+
+	h := startHarness(t, fakePair("XBT", "USD"))
+
+	// The pongs keep the idle connection alive
+	<-time.After(600 * time.Millisecond)
+
+	if n := h.exchange.Pings(); n == 0 {
+		t.Error("no ping events sent on the idle connection")
+	}
+	if n := h.exchange.Connections(); n != 1 {
+		t.Fatalf("idle connection caused %v connections", n)
+	}
+
+	h.exchange.Trade("XBT/USD", trade("1", 1))
+	h.waitTrades(t, 1)
+
+	// Half-open connection, nothing arrives anymore
+	h.exchange.Silence(time.Second)
+	h.exchange.Trade("XBT/USD", trade("2", 2))
+
+	h.waitFor(t, "reconnect", func() bool { return h.exchange.Connections() >= 2 })
+
+	// Recovered by the backfill of the new connection
+	assertPrices(t, h.waitTrades(t, 2), "1", "2")
+}
+
+func TestFetchTradesCloseDrainsTrades(t *testing.T) {
+	h := startHarness(t, fakePair("XBT", "USD"))
+
+	want := make([]string, 0)
+	for i := 1; i <= 50; i++ {
+		price := strconv.Itoa(1000 + i)
+		h.exchange.Trade("XBT/USD", trade(price, float64(i)))
+		want = append(want, price)
+	}
+
+	h.socket.Close()
+
+	select {
+	case <-h.fetched:
+	case <-time.After(e2eTimeout):
+		t.Fatal("timed out waiting for the fetch loop to end")
+	}
+
+	// Closing the sink flushes the buffered trades right away
+	h.sink.Close()
+
+	prices := make([]string, 0)
+	for _, trade := range h.service.Trades() {
+		prices = append(prices, trade.Price)
+	}
+	sort.Strings(prices)
+
+	assertPrices(t, prices, want...)
+
+	if n := h.exchange.Closes(); n != 1 {
+		t.Errorf("got %v closing handshakes, want 1", n)
+	}
+	if n := h.exchange.Connections(); n != 1 {
+		t.Errorf("close caused %v connections", n)
+	}
+}
+
+func TestFetchTradesCloseCancelsBackfill(t *testing.T) {
+	bases := []string{"XBT", "ETH", "LTC", "DOT", "ADA"}
+	pairs := make([]Pair, 0, len(bases))
+	for _, base := range bases {
+		pairs = append(pairs, fakePair(base, "USD"))
+	}
+
+	h := startHarness(t, pairs...)
+
+	for i, base := range bases {
+		h.exchange.Trade(base+"/USD", trade(strconv.Itoa(i+1), float64(i+1)))
+	}
+	h.waitTrades(t, len(bases))
+
+	h.exchange.Drop()
+	h.waitFor(t, "resubscription", func() bool { return len(h.exchange.Subscriptions()) == 2 })
+
+	// Held back by the tracker until the backfill of their pair completes
+	want := []string{"1", "2", "3", "4", "5"}
+	for i, base := range bases {
+		price := strconv.Itoa(i + 11)
+		h.exchange.Trade(base+"/USD", trade(price, float64(i+11)))
+		want = append(want, price)
+	}
+	sort.Strings(want)
+
+	closed := time.Now()
+	h.socket.Close()
+
+	select {
+	case <-h.fetched:
+	case <-time.After(e2eTimeout):
+		t.Fatal("timed out waiting for the fetch loop to end")
+	}
+
+	// The backfill pauses a second after every pair unless it is cancelled
+	if d := time.Since(closed); d > 2*time.Second {
+		t.Errorf("fetch loop ended %v after the close", d)
+	}
+
+	h.sink.Close()
+
+	prices := make([]string, 0)
+	for _, trade := range h.service.Trades() {
+		prices = append(prices, trade.Price)
+	}
+	sort.Strings(prices)
+
+	assertPrices(t, prices, want...)
+}
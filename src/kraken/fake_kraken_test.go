@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	grpc "google.golang.org/grpc"
+
+	"collector"
+)
+
+// Trade as scripted by the test
+type fakeTrade struct {
+	Price  string
+	Volume string
+	Time   float64
+	Side   string
+	Type   string
+	ID     int64
+}
+
+// Fake Kraken exchange serving the AssetPairs and Trades REST endpoints and the public websocket.
+// Trades are scripted by the test and delivered to every connection subscribed to the pair.
+type fakeKraken struct {
+	*httptest.Server
+	pairs    map[string]Pair
+	upgrader websocket.Upgrader
+
+	mu            sync.Mutex
+	conns         map[*fakeSocket]bool
+	accepted      int
+	closes        int
+	channels      map[string]int
+	trades        map[string][]fakeTrade
+	subscriptions []Message
+	pings         int
+	resume        time.Time
+}
+
+// Websocket connection of the fake exchange, frames are written by a separate goroutine
+// so the test is never blocked by the client. A nil frame confirms the close handshake
+// after the frames queued before it.
+type fakeSocket struct {
+	ws     *websocket.Conn
+	pairs  map[string]bool
+	frames chan []byte
+	server *fakeKraken
+}
+
+func newFakeKraken(t *testing.T, pairs ...Pair) *fakeKraken {
+	f := &fakeKraken{
+		pairs:    make(map[string]Pair),
+		conns:    make(map[*fakeSocket]bool),
+		channels: make(map[string]int),
+		trades:   make(map[string][]fakeTrade),
+	}
+
+	for _, pair := range pairs {
+		f.pairs[pair.Altname] = pair
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/0/public/AssetPairs", f.serveAssetPairs)
+	mux.HandleFunc("/0/public/Trades", f.serveTrades)
+	mux.HandleFunc("/", f.serveWebsocket)
+
+	// Closing the server stops accepting connections, the open ones stay up until the test binary exits
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Server.Close)
+
+	return f
+}
+
+func fakePair(base string, quote string) Pair {
+	return Pair{
+		Altname: base + quote,
+		Wsname:  base + "/" + quote,
+		Base:    base,
+		Quote:   quote,
+	}
+}
+
+// WebsocketURL returns the endpoint to use instead of the Kraken websocket
+func (f *fakeKraken) WebsocketURL() string {
+	return "ws" + strings.TrimPrefix(f.URL, "http")
+}
+
+// Trade adds the trade to the history of the pair and sends it to the subscribed connections
+func (f *fakeKraken) Trade(wsname string, trade fakeTrade) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.trades[wsname] = append(f.trades[wsname], trade)
+
+	row := []interface{}{trade.Price, trade.Volume, strconv.FormatFloat(trade.Time, 'f', 6, 64), trade.Side, trade.Type, ""}
+	data, _ := json.Marshal([]interface{}{f.channel(wsname), []interface{}{row}, "trade", wsname})
+
+	for conn := range f.conns {
+		if conn.pairs[wsname] {
+			conn.frames <- data
+		}
+	}
+}
+
+// Heartbeat sends the heartbeat event to all connections
+func (f *fakeKraken) Heartbeat() {
+	f.SendRaw([]byte(`{"event":"heartbeat"}`))
+}
+
+// SendRaw sends the frame as is to all connections, e.g. to simulate malformed messages
+func (f *fakeKraken) SendRaw(data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		conn.frames <- data
+	}
+}
+
+// Silence holds back all frames including the pongs for the duration, the connections stay open
+func (f *fakeKraken) Silence(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resume = time.Now().Add(d)
+}
+
+// Pings returns the number of ping events received on all connections
+func (f *fakeKraken) Pings() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pings
+}
+
+// Drop closes all connections without the closing handshake
+func (f *fakeKraken) Drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		conn.ws.UnderlyingConn().Close()
+	}
+}
+
+// Connections returns the number of accepted websocket connections
+func (f *fakeKraken) Connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accepted
+}
+
+// Closes returns the number of closing handshakes started by the clients
+func (f *fakeKraken) Closes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closes
+}
+
+// Subscriptions returns the subscribe and unsubscribe messages received on all connections
+func (f *fakeKraken) Subscriptions() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.subscriptions...)
+}
+
+// Returns the channel id of the pair trades, must be called with the lock held
+func (f *fakeKraken) channel(wsname string) int {
+	id, ok := f.channels[wsname]
+	if !ok {
+		id = len(f.channels) + 100
+		f.channels[wsname] = id
+	}
+	return id
+}
+
+func (f *fakeKraken) serveAssetPairs(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  []string{},
+		"result": f.pairs,
+	})
+}
+
+func (f *fakeKraken) serveTrades(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pair, ok := f.pairs[query.Get("pair")]
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": []string{"EQuery:Unknown asset pair"}})
+		return
+	}
+
+	since, _ := strconv.ParseInt(query.Get("since"), 10, 64)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows := make([][]interface{}, 0)
+	last := since
+	for _, trade := range f.trades[pair.Wsname] {
+		nanos := int64(trade.Time * 1e9)
+		if nanos <= since {
+			continue
+		}
+		rows = append(rows, []interface{}{trade.Price, trade.Volume, trade.Time, trade.Side, trade.Type, "", trade.ID})
+		if nanos > last {
+			last = nanos
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": []string{},
+		"result": map[string]interface{}{
+			pair.Altname: rows,
+			"last":       strconv.FormatInt(last, 10),
+		},
+	})
+}
+
+func (f *fakeKraken) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := f.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	conn := &fakeSocket{
+		ws:     ws,
+		pairs:  make(map[string]bool),
+		frames: make(chan []byte, 1024),
+		server: f,
+	}
+
+	f.mu.Lock()
+	f.conns[conn] = true
+	f.accepted++
+	f.mu.Unlock()
+
+	ws.SetCloseHandler(func(code int, text string) error {
+		f.mu.Lock()
+		f.closes++
+		f.mu.Unlock()
+
+		conn.frames <- nil
+		return nil
+	})
+
+	go conn.write()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+
+		close(conn.frames)
+	}()
+
+	conn.frames <- []byte(`{"connectionID":1,"event":"systemStatus","status":"online","version":"1.8.0"}`)
+
+	for {
+		var message Message
+		if err := ws.ReadJSON(&message); err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		if message.Event == "ping" {
+			f.pings++
+			pong, _ := json.Marshal(map[string]interface{}{"event": "pong", "reqid": message.ReqID})
+			conn.frames <- pong
+			f.mu.Unlock()
+			continue
+		}
+
+		f.subscriptions = append(f.subscriptions, message)
+
+		for _, wsname := range message.Pair {
+			status := map[string]interface{}{
+				"event":        "subscriptionStatus",
+				"pair":         wsname,
+				"subscription": message.Subscription,
+			}
+
+			switch message.Event {
+			case "subscribe":
+				status["status"] = "subscribed"
+				status["channelName"] = message.Subscription["name"]
+				status["channelID"] = f.channel(wsname)
+				if message.Subscription["name"] == "trade" {
+					conn.pairs[wsname] = true
+				}
+			case "unsubscribe":
+				status["status"] = "unsubscribed"
+				if message.Subscription["name"] == "trade" {
+					delete(conn.pairs, wsname)
+				}
+			}
+
+			data, _ := json.Marshal(status)
+			conn.frames <- data
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (s *fakeSocket) write() {
+	defer s.ws.Close()
+
+	for data := range s.frames {
+		s.server.mu.Lock()
+		resume := s.server.resume
+		s.server.mu.Unlock()
+
+		if wait := time.Until(resume); wait > 0 {
+			<-time.After(wait)
+		}
+
+		if data == nil {
+			s.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+
+		if err := s.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+	}
+}
+
+// Fake aggregator recording the pushed trades
+type fakeSyncService struct {
+	collector.UnimplementedSyncServiceServer
+
+	mu     sync.Mutex
+	trades []*collector.TradeRequest
+}
+
+// Starts the fake aggregator on a random port and returns its address
+func startFakeSyncService(t *testing.T) (*fakeSyncService, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &fakeSyncService{}
+	server := grpc.NewServer()
+	collector.RegisterSyncServiceServer(server, service)
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return service, listener.Addr().String()
+}
+
+func (s *fakeSyncService) PushTrade(ctx context.Context, trade *collector.TradeRequest) (*collector.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades = append(s.trades, trade)
+	return &collector.Empty{}, nil
+}
+
+func (s *fakeSyncService) PushTradeBatch(ctx context.Context, batch *collector.TradeBatch) (*collector.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades = append(s.trades, batch.Trades...)
+	return &collector.Empty{}, nil
+}
+
+// Trades returns the trades pushed so far
+func (s *fakeSyncService) Trades() []*collector.TradeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*collector.TradeRequest(nil), s.trades...)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"strings"
+
+	"collector"
+)
+
+var pairsInclude = flag.String("pairs", "", "comma separated glob patterns or /regular expressions/ of pairs to subscribe, empty subscribes all")
+var pairsExclude = flag.String("exclude-pairs", "", "comma separated glob patterns or /regular expressions/ of pairs to skip")
+var quoteAssets = flag.String("quote-assets", "", "comma separated quote assets to subscribe, empty subscribes all")
+var darkpool = flag.Bool("darkpool", false, "subscribe darkpool (.d) pairs")
+var filterFile = flag.String("filter", "", "json file with the pair filter, flags given on the command line take precedence")
+
+// Pair filter settings as read from the filter file
+type FilterConfig struct {
+	Pairs        []string `json:"pairs"`
+	ExcludePairs []string `json:"excludePairs"`
+	QuoteAssets  []string `json:"quoteAssets"`
+	Darkpool     bool     `json:"darkpool"`
+}
+
+// Decides which pairs get subscribed
+type PairFilter struct {
+	include     collector.PatternList
+	exclude     collector.PatternList
+	quoteAssets map[string]bool
+	darkpool    bool
+}
+
+// LoadFilterConfig reads the filter settings from the filter file and the command line flags
+func LoadFilterConfig() (FilterConfig, error) {
+	config := filterConfigFromFlags()
+
+	if *filterFile == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(*filterFile)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+
+	flags := filterConfigFromFlags()
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pairs":
+			config.Pairs = flags.Pairs
+		case "exclude-pairs":
+			config.ExcludePairs = flags.ExcludePairs
+		case "quote-assets":
+			config.QuoteAssets = flags.QuoteAssets
+		case "darkpool":
+			config.Darkpool = flags.Darkpool
+		}
+	})
+
+	return config, nil
+}
+
+func filterConfigFromFlags() FilterConfig {
+	return FilterConfig{
+		Pairs:        collector.SplitList(*pairsInclude),
+		ExcludePairs: collector.SplitList(*pairsExclude),
+		QuoteAssets:  collector.SplitList(*quoteAssets),
+		Darkpool:     *darkpool,
+	}
+}
+
+func NewPairFilter(config FilterConfig) (*PairFilter, error) {
+	include, err := collector.ParsePatterns(config.Pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := collector.ParsePatterns(config.ExcludePairs)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]bool)
+	for _, asset := range config.QuoteAssets {
+		quotes[collector.NormalizeAsset(asset)] = true
+	}
+
+	return &PairFilter{
+		include:     include,
+		exclude:     exclude,
+		quoteAssets: quotes,
+		darkpool:    config.Darkpool,
+	}, nil
+}
+
+// Allow reports whether the pair should be subscribed
+func (f *PairFilter) Allow(p Pair) bool {
+	if p.Wsname == "" {
+		return false
+	}
+
+	if !f.darkpool && strings.HasSuffix(p.Altname, ".d") {
+		return false
+	}
+
+	if len(f.quoteAssets) > 0 && !f.quoteAssets[p.Instrument().Quote] {
+		return false
+	}
+
+	if len(f.include) > 0 && !f.include.Match(p.Wsname) {
+		return false
+	}
+
+	return !f.exclude.Match(p.Wsname)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestPairFilter(t *testing.T) {
+	darkpool := fakePair("XBT", "EUR")
+	darkpool.Altname = "XBTEUR.d"
+
+	for _, test := range []struct {
+		name   string
+		config FilterConfig
+		pair   Pair
+		allow  bool
+	}{
+		{"no filter", FilterConfig{}, fakePair("XBT", "USD"), true},
+		{"no websocket name", FilterConfig{}, Pair{Altname: "XBTUSD", Base: "XBT", Quote: "USD"}, false},
+		{"glob include", FilterConfig{Pairs: []string{"XBT/*"}}, fakePair("XBT", "USD"), true},
+		{"glob include miss", FilterConfig{Pairs: []string{"XBT/*"}}, fakePair("ETH", "USD"), false},
+		{"regex include", FilterConfig{Pairs: []string{"/^(ETH|XBT)/EUR$/"}}, fakePair("ETH", "EUR"), true},
+		{"regex include miss", FilterConfig{Pairs: []string{"/^(ETH|XBT)/EUR$/"}}, fakePair("ETH", "USD"), false},
+		{"exclude over include", FilterConfig{Pairs: []string{"*/USD"}, ExcludePairs: []string{"ETH/*"}}, fakePair("ETH", "USD"), false},
+		{"quote allowlist", FilterConfig{QuoteAssets: []string{"usd", "EUR"}}, fakePair("XBT", "EUR"), true},
+		{"quote allowlist alias", FilterConfig{QuoteAssets: []string{"BTC"}}, fakePair("ETH", "XBT"), true},
+		{"quote allowlist miss", FilterConfig{QuoteAssets: []string{"USD"}}, fakePair("ETH", "XBT"), false},
+		{"darkpool excluded", FilterConfig{}, darkpool, false},
+		{"darkpool allowed", FilterConfig{Darkpool: true}, darkpool, true},
+	} {
+		filter, err := NewPairFilter(test.config)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		if got := filter.Allow(test.pair); got != test.allow {
+			t.Errorf("%v: got %v for %v, want %v", test.name, got, test.pair.Altname, test.allow)
+		}
+	}
+}
+
+func TestLoadFilterConfigFlagsOverrideFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.json")
+	err := ioutil.WriteFile(path, []byte(`{"pairs":["XBT/*"],"quoteAssets":["EUR"],"darkpool":true}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldFile, oldQuotes := *filterFile, *quoteAssets
+	t.Cleanup(func() { *filterFile, *quoteAssets = oldFile, oldQuotes })
+
+	*filterFile = path
+	// Given on the command line
+	if err := flag.Set("quote-assets", "USD,USDT"); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadFilterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Pairs) != 1 || config.Pairs[0] != "XBT/*" {
+		t.Errorf("got pairs %v, want the ones of the file", config.Pairs)
+	}
+	if len(config.QuoteAssets) != 2 || config.QuoteAssets[0] != "USD" {
+		t.Errorf("got quote assets %v, want the ones of the flag", config.QuoteAssets)
+	}
+	if !config.Darkpool {
+		t.Error("darkpool setting of the file not applied")
+	}
+}
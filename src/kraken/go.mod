@@ -3,8 +3,12 @@ module kraken
 go 1.16
 
 require (
-	github.com/gorilla/websocket v1.4.2 // indirect
-	google.golang.org/grpc v1.36.1 // indirect
+	collector v0.0.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/prometheus/client_golang v1.10.0
+	google.golang.org/grpc v1.36.1
 	google.golang.org/grpc/cmd/protoc-gen-go-grpc v1.1.0 // indirect
-	google.golang.org/protobuf v1.26.0 // indirect
+	google.golang.org/protobuf v1.26.0
 )
+
+replace collector => ../collector
@@ -1,21 +1,26 @@
 package main
 
 import (
-	context "context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	grpc "google.golang.org/grpc"
+
+	"collector"
 )
 
-var addr = flag.String("addr", "ws.kraken.com", "websocket server endpoint")
-var grpcaddr = flag.String("grpc", "127.0.0.1:50051", "grpc server endpoint")
+var krakenApi = flag.String("kraken", "https://api.kraken.com", "kraken rest api")
+var addr = flag.String("addr", "ws.kraken.com", "websocket server endpoint, wss is used unless the url has a scheme")
+var bookDepth = flag.Int("book-depth", 10, "order book subscription depth (10, 25, 100, 500 or 1000), 0 disables books")
+var staleTiers = flag.String("stale-tiers", "*=30m", "comma separated pattern=threshold tiers, pairs without events for longer than the threshold of the first matching pattern are resubscribed, empty disables the detection")
 
 type Pair struct {
 	Altname             string
@@ -38,159 +43,388 @@ type Pair struct {
 	Ordermin            interface{}
 }
 
+// Instrument returns the exchange independent description of the pair,
+// the zero Instrument when the pair doesn't name both assets
+func (p Pair) Instrument() collector.Instrument {
+	base, quote := trimAssetClass(p.Base), trimAssetClass(p.Quote)
+	if assets := strings.Split(p.Wsname, "/"); len(assets) == 2 {
+		base, quote = assets[0], assets[1]
+	}
+
+	if strings.TrimSpace(base) == "" || strings.TrimSpace(quote) == "" {
+		return collector.Instrument{}
+	}
+
+	return collector.NewInstrument(base, quote)
+}
+
+// Legacy Kraken asset codes are prefixed with X for crypto and Z for fiat, e.g. XXBT or ZUSD
+func trimAssetClass(asset string) string {
+	if len(asset) == 4 && (asset[0] == 'X' || asset[0] == 'Z') {
+		return asset[1:]
+	}
+	return asset
+}
+
 type AssetPairs struct {
-	Rrr    []interface{}
-	Result map[string]Pair
+	Error  []string        `json:"error"`
+	Result map[string]Pair `json:"result"`
 }
 
 type Message struct {
-	Event        string            `json:"event"`
-	Pair         []string          `json:"pair"`
-	Subscription map[string]string `json:"subscription"`
+	Event        string                 `json:"event"`
+	Pair         []string               `json:"pair,omitempty"`
+	Subscription map[string]interface{} `json:"subscription,omitempty"`
+	ReqID        int                    `json:"reqid,omitempty"`
 }
 
 func main() {
 	flag.Parse()
 
-	pairs := loadPairs()
-	conn := connectGRPC()
-	fetchTrades(pairs, conn)
-}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
 
-func connectGRPC() *grpc.ClientConn {
-	log.Println("Connecting to GRPC server:", grpcaddr)
-	conn, err := grpc.Dial(*grpcaddr, grpc.WithInsecure(), grpc.WithBlock())
+	filterConfig, err := LoadFilterConfig()
+	if err != nil {
+		log.Fatalf("could not load pair filter: %v", err)
+	}
 
+	filter, err := NewPairFilter(filterConfig)
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		log.Fatalf("could not parse pair filter: %v", err)
+	}
+
+	tiers, err := collector.ParseStalenessTiers(*staleTiers)
+	if err != nil {
+		log.Fatalf("could not parse staleness tiers: %v", err)
+	}
+
+	quarantine, err = OpenQuarantine(*quarantineFile)
+	if err != nil {
+		log.Fatalf("could not open quarantine: %v", err)
+	}
+	defer quarantine.Close()
+
+	sink, err := collector.OpenSinks(*collector.Sinks, "kraken")
+	if err != nil {
+		log.Fatalf("could not open sinks: %v", err)
+	}
+
+	if *collector.ReplayFile != "" {
+		replay(*collector.ReplayFile, filter, sink)
+		sink.Close()
+		return
+	}
+
+	if *collector.RecordFile != "" {
+		collector.Recorder, err = collector.CreateCapture(*collector.RecordFile)
+		if err != nil {
+			log.Fatalf("could not create capture: %v", err)
+		}
+		defer collector.Recorder.Close()
 	}
 
-	return conn
+	pairs := NewPairs(loadPairs(filter))
+	books := NewOrderBooks(*bookDepth)
+	socket := &KrakenSocket{}
+	stop := collector.ShutdownSignal()
+
+	collector.DefaultHealth.AddChecker("sink", sink)
+	collector.DefaultHealth.AddChecker("websocket", socket)
+
+	if *refreshInterval > 0 {
+		go refreshPairs(*refreshInterval, filter, pairs, socket, books)
+	}
+
+	if len(tiers) > 0 {
+		go watchStaleness(tiers, pairs, socket)
+	}
+
+	fetched := make(chan struct{})
+
+	go func() {
+		fetchTrades(pairs, socket, sink, books, NewTradeTracker())
+		close(fetched)
+	}()
+
+	<-stop
+	deadline := time.Now().Add(*collector.ShutdownTimeout)
+
+	// The fetch loop ends once the closing handshake completes and the backfills stop, the sinks then
+	// flush or spool the rest. They are closed even when the fetch loop misses the deadline,
+	// the trades pushed afterwards are dropped.
+	socket.Close()
+	collector.Drain(deadline, "websocket", func() { <-fetched })
+	sink.Close()
 }
 
-func fetchTrades(pairs []string, conn *grpc.ClientConn) {
-	client := NewSyncServiceClient(conn)
+// fetchTrades pushes the trades of the pairs and reconnects until the socket is closed
+func fetchTrades(pairs *Pairs, socket *KrakenSocket, sink collector.TradeSink, books *OrderBooks, tracker *TradeTracker) {
+	// The backfills push to the sink, so they have to finish before the sink may be closed
+	var backfills sync.WaitGroup
+	defer backfills.Wait()
 
-	for {
-		u := url.URL{Scheme: "wss", Host: *addr, Path: ""}
-		log.Println("Connecting to websocket server:", u.String())
-		ws, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	backoff := collector.NewBackoff(exchangeCircuit)
+
+	for attempt := 0; !socket.Closed() && backoff.Wait(socket.Done()); attempt++ {
+		if attempt > 0 {
+			kraken_websocket_connection_reconnects_total.Inc()
+		}
+
+		u := websocketURL(*addr)
+		log.Println("Connecting to websocket server:", u)
+		ws, resp, err := websocket.DefaultDialer.Dial(u, nil)
 
 		if err != nil {
+			kraken_websocket_connection_errors.Inc()
 			log.Println("Server error:", err)
-			log.Println("Retrying connection after 5 seconds.")
-			<-time.After(5 * time.Second)
+			backoff.Failure(resp)
 			continue
 		}
 
-		log.Printf("Subscibing for trade events.")
-		err = ws.WriteJSON(&Message{
-			Event: "subscribe",
-			Pair:  pairs,
-			Subscription: map[string]string{
-				"name": "trade",
-			},
-		})
+		keepalive := collector.StartKeepalive(ws, socket.Ping)
 
-		if err != nil {
+		if err := socket.Attach(ws, keepalive, pairs); err == errSocketClosed {
+			keepalive.Stop()
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			ws.Close()
+			return
+		} else if err != nil {
+			keepalive.Stop()
+			kraken_websocket_connection_errors.Inc()
 			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""))
 			ws.Close()
 			log.Println("Server error:", err)
-			log.Println("Reconnecting after 5 seconds.")
-			<-time.After(5 * time.Second)
+			backoff.Failure(nil)
 			continue
 		}
 
+		backoff.Success()
+
+		// The time without connection doesn't count towards staleness
+		pairs.Refresh(pairs.Wsnames())
+
+		// Recover the trades missed while reconnecting, the first connection has nothing to recover
+		if recovery, gaps := tracker.StartRecovery(); len(gaps) > 0 {
+			backfills.Add(1)
+			go func() {
+				defer backfills.Done()
+				backfillTrades(socket.Done(), recovery, gaps, pairs, tracker, sink)
+			}()
+		}
+
+		conn := collector.NextConnectionID()
+		log.Printf("Websocket connection %v established.", conn)
+		kraken_websocket_connections_open.Inc()
+
 		for {
-			var message interface{}
-			if err := ws.ReadJSON(&message); err != nil {
+			_, data, err := ws.ReadMessage()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) && socket.Closed() {
+				kraken_websocket_connections_open.Dec()
+				log.Printf("Websocket connection %v closed.", conn)
+				socket.Detach()
+				ws.Close()
+				return
+			}
+
+			if keepalive.TimedOut(err) {
+				kraken_websocket_read_timeouts_total.Inc()
+				log.Println("No frames within the read timeout, reconnecting.")
+			}
+
+			if err != nil {
+				kraken_websocket_connection_errors.Inc()
+				kraken_websocket_connections_open.Dec()
 				log.Println("read:", err)
 				log.Println("Closing connection!")
+				socket.Detach()
 				ws.Close()
 				break
-			} else {
-				for _, trade := range parseTrades(message) {
-					pushTrade(trade, client)
-				}
 			}
+
+			keepalive.Alive()
+			collector.Recorder.Write(conn, time.Now(), data)
+			handleMessage(data, pairs, socket, sink, books, tracker)
 		}
 	}
 }
 
-func loadPairs() []string {
-	for {
-		log.Println("Fetching assets from:", "https://api.kraken.com/0/public/AssetPairs")
-		resp, err := http.Get("https://api.kraken.com/0/public/AssetPairs")
-		if err != nil {
-			log.Println("Server error:", err)
-			log.Println("Waiting 5 seconds before retry...")
-			<-time.After(5 * time.Second)
-			continue
+// Decodes the websocket message and applies it to the order books or pushes the trades
+func handleMessage(data []byte, pairs *Pairs, socket *KrakenSocket, sink collector.TradeSink, books *OrderBooks, tracker *TradeTracker) {
+	message, err := DecodeMessage(data)
+	if err != nil {
+		quarantine.Add(data, err)
+		return
+	}
+
+	kraken_websocket_frames_total.WithLabelValues(message.Kind).Inc()
+
+	// Heartbeats and status messages keep arriving for dead subscriptions, only market data makes the service ready
+	switch message.Kind {
+	case KIND_TRADE:
+		collector.DefaultHealth.Event()
+		kraken_trades_parsed_total.Add(float64(len(message.Trades)))
+		if len(message.Trades) > 0 {
+			lastTrades.Observe(message.Trades[0].Symbol)
+			pairs.Touch(message.Trades[0].Symbol)
+		}
+
+		for _, trade := range tracker.Live(message.Trades) {
+			pairs.Get(trade.Symbol).Instrument().Apply(trade)
+			sink.Push(trade)
 		}
+	case KIND_BOOK:
+		collector.DefaultHealth.Event()
+		pairs.Touch(message.Book.Pair)
+		handleBook(message.Book, socket, books)
+	case KIND_HEARTBEAT:
+		kraken_websocket_last_heartbeat_timestamp_seconds.SetToCurrentTime()
+	case KIND_SYSTEM_STATUS:
+		log.Println("System status:", message.Event.Status)
+	case KIND_SUBSCRIPTION_STATUS:
+		if message.Event.Status == "error" {
+			log.Printf("Subscription of %v failed: %v", message.Event.Pair, message.Event.ErrorMessage)
+		}
+	case "error":
+		log.Println("Server error:", message.Event.ErrorMessage)
+	}
+}
+
+func handleBook(message *BookMessage, socket *KrakenSocket, books *OrderBooks) {
+	err := books.Handle(message)
+	if err == nil {
+		return
+	}
+
+	log.Printf("Book %v: %v", message.Pair, err)
+
+	// The local book can't be trusted anymore, start over with a fresh snapshot
+	books.Reset(message.Pair)
+	log.Printf("Resubscribing for %v book events.", message.Pair)
+	socket.WriteJSON(bookSubscription("unsubscribe", []string{message.Pair}))
+	socket.WriteJSON(bookSubscription("subscribe", []string{message.Pair}))
+}
+
+// Feeds the recorded frames through the trade pipeline instead of connecting to the websocket.
+// Missed trades are not recovered and book resubscriptions are dropped since there is no connection.
+func replay(path string, filter *PairFilter, sink collector.TradeSink) {
+	loaded, err := requestPairs(filter)
+	if err != nil {
+		log.Println("Could not load pairs, instruments are not resolved:", err)
+	}
+
+	pairs := NewPairs(loaded)
+	books := NewOrderBooks(*bookDepth)
+	socket := &KrakenSocket{}
+	tracker := NewTradeTracker()
+
+	err = collector.ReplayCapture(path, *collector.ReplaySpeed, func(frame collector.CaptureFrame) {
+		handleMessage(frame.Data, pairs, socket, sink, books, tracker)
+	})
+
+	if err != nil {
+		log.Println("Replay:", err)
+	}
+}
+
+// Kraken is reached over wss, plain ws endpoints are meant for local testing
+func websocketURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+
+	u := url.URL{Scheme: "wss", Host: addr, Path: ""}
+	return u.String()
+}
 
-		var pairsResponse AssetPairs
-		err = json.NewDecoder(resp.Body).Decode(&pairsResponse)
+func bookSubscription(event string, pairs []string) *Message {
+	return &Message{
+		Event: event,
+		Pair:  pairs,
+		Subscription: map[string]interface{}{
+			"name":  "book",
+			"depth": *bookDepth,
+		},
+	}
+}
+
+func loadPairs(filter *PairFilter) []Pair {
+	backoff := collector.NewBackoff(exchangeCircuit)
+
+	for {
+		backoff.Wait(nil)
+
+		pairs, err := requestPairs(filter)
 		if err != nil {
 			log.Println("Server error:", err)
-			log.Println("Waiting 5 seconds before retry...")
-			<-time.After(5 * time.Second)
+			backoff.Failure(nil)
 			continue
 		}
 
-		var pairs = make([]string, 0)
-		for _, pair := range pairsResponse.Result {
-			if pair.Wsname != "" {
-				pairs = append(pairs, pair.Wsname)
-			}
-		}
-
+		backoff.Success()
 		return pairs
 	}
 }
 
-func pushTrade(trade *TradeRequest, client SyncServiceClient) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	_, err := client.PushTrade(ctx, trade)
+func requestPairs(filter *PairFilter) ([]Pair, error) {
+	log.Println("Fetching assets from:", *krakenApi+"/0/public/AssetPairs")
+	resp, err := http.Get(*krakenApi + "/0/public/AssetPairs")
 	if err != nil {
-		log.Printf("Could not push trade: %v", err)
-	} else {
-		// log.Println("Successfully pushed trade", trade)
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		exchangeCircuit.Observe(resp)
+		return nil, errors.New(resp.Status)
+	}
+
+	var pairsResponse AssetPairs
+	err = json.NewDecoder(resp.Body).Decode(&pairsResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// Kraken reports errors like an unavailable service with 200 OK
+	if len(pairsResponse.Error) > 0 {
+		observeErrors(exchangeCircuit, pairsResponse.Error)
+		return nil, fmt.Errorf("%v", strings.Join(pairsResponse.Error, ", "))
 	}
-}
 
-func parseTrades(message interface{}) []*TradeRequest {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("Could not parse response:", r, message)
+	if len(pairsResponse.Result) == 0 {
+		return nil, errors.New("no asset pairs")
+	}
+
+	var pairs = make([]Pair, 0)
+	for _, pair := range pairsResponse.Result {
+		if filter.Allow(pair) {
+			pairs = append(pairs, pair)
 		}
-	}()
+	}
+
+	return pairs, nil
+}
 
-	_, ok := message.(map[string]interface{})
-	if ok {
-		// Ignore other messages
-		return make([]*TradeRequest, 0)
-	}
-
-	array := message.([]interface{})
-	symbol := array[3].(string)
-	trades := array[1].([]interface{})
-	parsed := make([]*TradeRequest, 0)
-
-	for _, trade := range trades {
-		t := trade.([]interface{})
-		price := t[0].(string)
-		volume := t[1].(string)
-		timestr := t[2].(string)
-		time, _ := strconv.ParseFloat(timestr, 64)
-		parsed = append(parsed, &TradeRequest{
-			Price:     price,
-			Quantity:  volume,
-			TradeTime: time,
-			Symbol:    symbol,
-			Exchange:  "kraken",
-		})
-	}
-
-	return parsed
+// Kraken reports the taker side as "b" or "s"
+func parseSide(side string) collector.Side {
+	switch side {
+	case "b":
+		return collector.Side_SIDE_BUY
+	case "s":
+		return collector.Side_SIDE_SELL
+	}
+	return collector.Side_SIDE_UNSPECIFIED
+}
+
+// Kraken reports the taker order type as "m" or "l"
+func parseOrderType(orderType string) collector.OrderType {
+	switch orderType {
+	case "m":
+		return collector.OrderType_ORDER_TYPE_MARKET
+	case "l":
+		return collector.OrderType_ORDER_TYPE_LIMIT
+	}
+	return collector.OrderType_ORDER_TYPE_UNSPECIFIED
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"collector"
+)
+
+func TestWebsocketURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		"ws.kraken.com":        "wss://ws.kraken.com",
+		"ws://127.0.0.1:8080":  "ws://127.0.0.1:8080",
+		"wss://beta-ws.kraken": "wss://beta-ws.kraken",
+	} {
+		if got := websocketURL(addr); got != want {
+			t.Errorf("websocketURL(%v) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestPairInstrument(t *testing.T) {
+	for _, test := range []struct {
+		pair Pair
+		want collector.Instrument
+	}{
+		{Pair{Wsname: "XBT/USD", Base: "XXBT", Quote: "ZUSD"}, collector.Instrument{Name: "BTC-USD", Base: "BTC", Quote: "USD"}},
+		{Pair{Base: "XETH", Quote: "ZEUR"}, collector.Instrument{Name: "ETH-EUR", Base: "ETH", Quote: "EUR"}},
+		{Pair{}, collector.Instrument{}},
+		{Pair{Wsname: "XBT/"}, collector.Instrument{}},
+		{Pair{Base: "XXBT"}, collector.Instrument{}},
+	} {
+		if got := test.pair.Instrument(); got != test.want {
+			t.Errorf("%+v.Instrument() = %+v, want %+v", test.pair, got, test.want)
+		}
+	}
+}
+
+func TestRequestPairsReportsErrors(t *testing.T) {
+	filter, err := NewPairFilter(FilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldApi := *krakenApi
+	t.Cleanup(func() { *krakenApi = oldApi })
+
+	for _, body := range []string{
+		`{"error":["EService:Unavailable"]}`,
+		`{"error":[],"result":{}}`,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		*krakenApi = server.URL
+
+		if pairs, err := requestPairs(filter); err == nil {
+			t.Errorf("requestPairs got %v pairs without error for %v", len(pairs), body)
+		}
+
+		server.Close()
+	}
+}
+
+func TestRequestPairsHoldsCircuitWhenRateLimited(t *testing.T) {
+	filter, err := NewPairFilter(FilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldApi, oldBase := *krakenApi, *collector.BackoffBase
+	t.Cleanup(func() { *krakenApi, *collector.BackoffBase = oldApi, oldBase })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":["` + RATE_LIMIT_ERROR + `"]}`))
+	}))
+	defer server.Close()
+
+	*krakenApi = server.URL
+	*collector.BackoffBase = 50 * time.Millisecond
+
+	if _, err := requestPairs(filter); err == nil {
+		t.Fatal("requestPairs succeeded while rate limited")
+	}
+
+	if wait := exchangeCircuit.Remaining(); wait <= 0 {
+		t.Error("rate limited AssetPairs response did not hold the circuit")
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"collector"
+)
+
+// Kinds of the websocket messages
+const (
+	KIND_TRADE               = "trade"
+	KIND_BOOK                = "book"
+	KIND_TICKER              = "ticker"
+	KIND_HEARTBEAT           = "heartbeat"
+	KIND_SYSTEM_STATUS       = "systemStatus"
+	KIND_SUBSCRIPTION_STATUS = "subscriptionStatus"
+	KIND_UNKNOWN             = "unknown"
+)
+
+var quarantineFile = flag.String("quarantine", "", "file to append the unparseable websocket frames to, empty disables the quarantine")
+
+var kraken_parse_errors_total = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kraken_parse_errors_total",
+	Help: "The total number of websocket frames which could not be parsed by message kind",
+}, []string{"kind"})
+
+var (
+	ErrUnknownMessage = errors.New("unknown message")
+	ErrInvalidFrame   = errors.New("invalid channel frame")
+)
+
+// Error of a frame which could not be decoded
+type ParseError struct {
+	Kind string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid %v message: %v", e.Kind, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Decoded websocket message, the field matching the kind is set
+type KrakenMessage struct {
+	Kind   string
+	Event  *EventMessage
+	Trades []*collector.TradeRequest
+	Book   *BookMessage
+	Ticker *TickerMessage
+}
+
+// Object framed message like heartbeat, systemStatus or subscriptionStatus
+type EventMessage struct {
+	Event        string                 `json:"event"`
+	Status       string                 `json:"status"`
+	Pair         string                 `json:"pair"`
+	ChannelID    int64                  `json:"channelID"`
+	ChannelName  string                 `json:"channelName"`
+	Subscription map[string]interface{} `json:"subscription"`
+	ErrorMessage string                 `json:"errorMessage"`
+	ConnectionID uint64                 `json:"connectionID"`
+	Version      string                 `json:"version"`
+}
+
+// Book snapshot or update of a single pair
+type BookMessage struct {
+	Pair     string
+	Snapshot bool
+	Asks     []BookLevel
+	Bids     []BookLevel
+	Checksum string
+}
+
+// Best prices and daily statistics of a single pair
+type TickerMessage struct {
+	Pair   string
+	Ask    []tickerValue `json:"a"`
+	Bid    []tickerValue `json:"b"`
+	Close  []tickerValue `json:"c"`
+	Volume []tickerValue `json:"v"`
+	VWAP   []tickerValue `json:"p"`
+	Trades []tickerValue `json:"t"`
+	Low    []tickerValue `json:"l"`
+	High   []tickerValue `json:"h"`
+	Open   []tickerValue `json:"o"`
+}
+
+// Ticker values are mostly strings with a few integers in between
+type tickerValue string
+
+func (v *tickerValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = tickerValue(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+
+	*v = tickerValue(n)
+	return nil
+}
+
+// Payload of book messages, snapshots use as/bs and updates a/b
+type bookPayload struct {
+	AsksSnapshot [][]string `json:"as"`
+	BidsSnapshot [][]string `json:"bs"`
+	Asks         [][]string `json:"a"`
+	Bids         [][]string `json:"b"`
+	Checksum     string     `json:"c"`
+}
+
+// DecodeMessage decodes the websocket frame, the frames which don't match the expected format return ParseError
+func DecodeMessage(data []byte) (KrakenMessage, error) {
+	data = bytes.TrimSpace(data)
+
+	switch {
+	case len(data) > 0 && data[0] == '{':
+		return decodeEvent(data)
+	case len(data) > 0 && data[0] == '[':
+		return decodeChannelMessage(data)
+	}
+
+	return KrakenMessage{}, &ParseError{Kind: KIND_UNKNOWN, Err: ErrUnknownMessage}
+}
+
+func decodeEvent(data []byte) (KrakenMessage, error) {
+	var event EventMessage
+	if err := json.Unmarshal(data, &event); err != nil {
+		return KrakenMessage{}, &ParseError{Kind: KIND_UNKNOWN, Err: err}
+	}
+
+	switch event.Event {
+	case KIND_HEARTBEAT, KIND_SYSTEM_STATUS, KIND_SUBSCRIPTION_STATUS, "pong", "error":
+		return KrakenMessage{Kind: event.Event, Event: &event}, nil
+	}
+
+	return KrakenMessage{}, &ParseError{Kind: KIND_UNKNOWN, Err: fmt.Errorf("%w: event %q", ErrUnknownMessage, event.Event)}
+}
+
+// Channel messages are arrays of the channel id, one or more payloads, the channel name and the pair
+func decodeChannelMessage(data []byte) (KrakenMessage, error) {
+	var frame []json.RawMessage
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return KrakenMessage{}, &ParseError{Kind: KIND_UNKNOWN, Err: err}
+	}
+
+	if len(frame) < 4 {
+		return KrakenMessage{}, &ParseError{Kind: KIND_UNKNOWN, Err: ErrInvalidFrame}
+	}
+
+	var name, pair string
+	if err := json.Unmarshal(frame[len(frame)-2], &name); err != nil {
+		return KrakenMessage{}, &ParseError{Kind: KIND_UNKNOWN, Err: fmt.Errorf("%w: channel name: %v", ErrInvalidFrame, err)}
+	}
+
+	kind := channelKind(name)
+
+	if err := json.Unmarshal(frame[len(frame)-1], &pair); err != nil {
+		return KrakenMessage{}, &ParseError{Kind: kind, Err: fmt.Errorf("%w: pair: %v", ErrInvalidFrame, err)}
+	}
+
+	payloads := frame[1 : len(frame)-2]
+	message := KrakenMessage{Kind: kind}
+	var err error
+
+	switch kind {
+	case KIND_TRADE:
+		message.Trades, err = decodeTrades(pair, payloads)
+	case KIND_BOOK:
+		message.Book, err = decodeBook(pair, payloads)
+	case KIND_TICKER:
+		message.Ticker, err = decodeTicker(pair, payloads)
+	default:
+		err = fmt.Errorf("%w: channel %q", ErrUnknownMessage, name)
+	}
+
+	if err != nil {
+		return KrakenMessage{}, &ParseError{Kind: kind, Err: err}
+	}
+
+	return message, nil
+}
+
+// Book channels are named after the subscription depth, e.g. "book-10"
+func channelKind(name string) string {
+	switch {
+	case name == KIND_TRADE, name == KIND_TICKER:
+		return name
+	case strings.HasPrefix(name, KIND_BOOK+"-"):
+		return KIND_BOOK
+	}
+	return KIND_UNKNOWN
+}
+
+// Trades are arrays of price, volume, time, side, order type and misc
+func decodeTrades(pair string, payloads []json.RawMessage) ([]*collector.TradeRequest, error) {
+	if len(payloads) != 1 {
+		return nil, ErrInvalidFrame
+	}
+
+	var rows [][]string
+	if err := json.Unmarshal(payloads[0], &rows); err != nil {
+		return nil, err
+	}
+
+	trades := make([]*collector.TradeRequest, 0, len(rows))
+
+	for _, row := range rows {
+		if len(row) < 5 {
+			return nil, fmt.Errorf("trade has %v fields", len(row))
+		}
+
+		tradeTime, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("trade time: %w", err)
+		}
+
+		trades = append(trades, &collector.TradeRequest{
+			Price:     row[0],
+			Quantity:  row[1],
+			TradeTime: tradeTime,
+			Symbol:    pair,
+			Exchange:  "kraken",
+			Side:      parseSide(row[3]),
+			OrderType: parseOrderType(row[4]),
+		})
+	}
+
+	return trades, nil
+}
+
+// Book updates may carry the asks and bids in two separate payloads
+func decodeBook(pair string, payloads []json.RawMessage) (*BookMessage, error) {
+	if len(payloads) == 0 || len(payloads) > 2 {
+		return nil, ErrInvalidFrame
+	}
+
+	book := &BookMessage{Pair: pair}
+
+	for _, raw := range payloads {
+		var payload bookPayload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return nil, err
+		}
+
+		if payload.AsksSnapshot != nil || payload.BidsSnapshot != nil {
+			book.Snapshot = true
+		}
+
+		for _, side := range []struct {
+			levels [][]string
+			target *[]BookLevel
+		}{
+			{payload.AsksSnapshot, &book.Asks},
+			{payload.BidsSnapshot, &book.Bids},
+			{payload.Asks, &book.Asks},
+			{payload.Bids, &book.Bids},
+		} {
+			for _, level := range side.levels {
+				if len(level) < 2 {
+					return nil, fmt.Errorf("book level has %v fields", len(level))
+				}
+				*side.target = append(*side.target, BookLevel{Price: level[0], Volume: level[1]})
+			}
+		}
+
+		if payload.Checksum != "" {
+			book.Checksum = payload.Checksum
+		}
+	}
+
+	return book, nil
+}
+
+func decodeTicker(pair string, payloads []json.RawMessage) (*TickerMessage, error) {
+	if len(payloads) != 1 {
+		return nil, ErrInvalidFrame
+	}
+
+	ticker := &TickerMessage{Pair: pair}
+	if err := json.Unmarshal(payloads[0], ticker); err != nil {
+		return nil, err
+	}
+
+	return ticker, nil
+}
+
+// Stores the frames which could not be decoded when the quarantine is enabled
+var quarantine *Quarantine
+
+// Appends the unparseable frames to the quarantine file for later inspection
+type Quarantine struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Entry of the quarantine file, the frame is kept as a string as it may not be valid JSON
+type quarantineEntry struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"`
+	Error string    `json:"error"`
+	Frame string    `json:"frame"`
+}
+
+// OpenQuarantine opens the quarantine file for appending, empty path returns nil which only counts and logs the errors
+func OpenQuarantine(path string) (*Quarantine, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Quarantining unparseable frames to:", path)
+
+	return &Quarantine{file: file}, nil
+}
+
+// Add counts the parse error and stores the frame
+func (q *Quarantine) Add(data []byte, err error) {
+	kind := KIND_UNKNOWN
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		kind = parseErr.Kind
+	}
+
+	kraken_parse_errors_total.WithLabelValues(kind).Inc()
+	log.Printf("Could not parse %v bytes: %v", len(data), err)
+
+	if q == nil {
+		return
+	}
+
+	entry, _ := json.Marshal(quarantineEntry{
+		Time:  time.Now().UTC(),
+		Kind:  kind,
+		Error: err.Error(),
+		Frame: string(data),
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.file.Write(append(entry, '\n')); err != nil {
+		log.Println("Quarantine write:", err)
+	}
+}
+
+func (q *Quarantine) Close() error {
+	if q == nil {
+		return nil
+	}
+	return q.file.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"collector"
+)
+
+func TestDecodeTrades(t *testing.T) {
+	message, err := DecodeMessage([]byte(`[0,[["5541.20000","0.15850568","1534614057.321597","s","l",""],["6060.00000","0.02455000","1534614057.324998","b","m",""]],"trade","XBT/USD"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.Kind != KIND_TRADE || len(message.Trades) != 2 {
+		t.Fatalf("got %v message with %v trades", message.Kind, len(message.Trades))
+	}
+
+	sell := message.Trades[0]
+	if sell.Symbol != "XBT/USD" || sell.Price != "5541.20000" || sell.Quantity != "0.15850568" || sell.Exchange != "kraken" {
+		t.Errorf("unexpected trade: %v", sell)
+	}
+	if sell.TradeTime != 1534614057.321597 {
+		t.Errorf("got trade time %v", sell.TradeTime)
+	}
+	if sell.Side != collector.Side_SIDE_SELL || sell.OrderType != collector.OrderType_ORDER_TYPE_LIMIT {
+		t.Errorf("got side %v and order type %v", sell.Side, sell.OrderType)
+	}
+
+	buy := message.Trades[1]
+	if buy.Side != collector.Side_SIDE_BUY || buy.OrderType != collector.OrderType_ORDER_TYPE_MARKET {
+		t.Errorf("got side %v and order type %v", buy.Side, buy.OrderType)
+	}
+}
+
+func TestDecodeBook(t *testing.T) {
+	snapshot, err := DecodeMessage([]byte(`[0,{"as":[["5541.30000","2.50700000","1534614248.123678"]],"bs":[["5541.20000","1.52900000","1534614248.765567"]]},"book-10","XBT/USD"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshot.Kind != KIND_BOOK || !snapshot.Book.Snapshot || len(snapshot.Book.Asks) != 1 || len(snapshot.Book.Bids) != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot.Book)
+	}
+
+	update, err := DecodeMessage([]byte(`[1234,{"a":[["5541.30000","2.50700000","1534614248.456738","r"]]},{"b":[["5541.20000","0.00000000","1534614248.456738"]],"c":"974942666"},"book-10","XBT/USD"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := update.Book
+	if book.Snapshot || book.Pair != "XBT/USD" || book.Checksum != "974942666" {
+		t.Fatalf("unexpected update: %+v", book)
+	}
+	if len(book.Asks) != 1 || len(book.Bids) != 1 || book.Bids[0].Volume != "0.00000000" {
+		t.Fatalf("unexpected levels: %+v", book)
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	for data, kind := range map[string]string{
+		`{"event":"heartbeat"}`: KIND_HEARTBEAT,
+		`{"connectionID":8628615390848610000,"event":"systemStatus","status":"online","version":"1.0.0"}`:                                               KIND_SYSTEM_STATUS,
+		`{"channelID":10001,"channelName":"trade","event":"subscriptionStatus","pair":"XBT/EUR","status":"subscribed","subscription":{"name":"trade"}}`: KIND_SUBSCRIPTION_STATUS,
+		`[340,{"a":["5525.40000",1,"1.000"],"b":["5525.10000",1,"1.000"],"c":["5525.10000","0.00398963"],"v":["2634.11501494","3591.17907851"],"p":["5631.44067","5653.78939"],"t":[11493,16267],"l":["5505.00000","5505.00000"],"h":["5783.00000","5783.00000"],"o":["5760.70000","5763.40000"]},"ticker","XBT/USD"]`: KIND_TICKER,
+	} {
+		message, err := DecodeMessage([]byte(data))
+		if err != nil {
+			t.Errorf("decode %v: %v", data, err)
+			continue
+		}
+		if message.Kind != kind {
+			t.Errorf("got kind %v, want %v", message.Kind, kind)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for data, kind := range map[string]string{
+		`{"event":"heartbeat"`:               KIND_UNKNOWN,
+		`{"event":"unexpected"}`:             KIND_UNKNOWN,
+		`"text"`:                             KIND_UNKNOWN,
+		`[0,"trade","XBT/USD"]`:              KIND_UNKNOWN,
+		`[0,{},"spread","XBT/USD"]`:          KIND_UNKNOWN,
+		`[0,"not trades","trade","XBT/USD"]`: KIND_TRADE,
+		`[0,[["5541.2"]],"trade","XBT/USD"]`: KIND_TRADE,
+		`[0,[["5541.2","0.1","noon","s","l",""]],"trade","XBT/USD"]`: KIND_TRADE,
+		`[0,[["1.0",0.5]],"trade","XBT/USD"]`:                        KIND_TRADE,
+		`[0,{"as":[["5541.3"]]},"book-10","XBT/USD"]`:                KIND_BOOK,
+		`[0,{"a":["5525.4"]},"ticker",1]`:                            KIND_TICKER,
+	} {
+		_, err := DecodeMessage([]byte(data))
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("decode %v: got %v, want ParseError", data, err)
+			continue
+		}
+		if parseErr.Kind != kind {
+			t.Errorf("decode %v: got kind %v, want %v", data, parseErr.Kind, kind)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"collector"
+)
+
+var metricsAddr = flag.String("metrics", ":2112", "listen address of the metrics and health endpoints, empty disables the endpoints")
+
+var (
+	kraken_websocket_connections_open = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kraken_websocket_connections_open",
+		Help: "The number of open websocket connections",
+	})
+	kraken_websocket_connection_reconnects_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kraken_websocket_connection_reconnects_total",
+		Help: "The total number of websocket connection attempts after the first one",
+	})
+	kraken_websocket_connection_errors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kraken_websocket_connection_errors",
+		Help: "The total number of failed websocket connects and reads",
+	})
+	kraken_websocket_read_timeouts_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kraken_websocket_read_timeouts_total",
+		Help: "The total number of connections reconnected because no frame arrived within the read timeout",
+	})
+	kraken_websocket_last_heartbeat_timestamp_seconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kraken_websocket_last_heartbeat_timestamp_seconds",
+		Help: "The unix time of the last heartbeat event sent by Kraken while the subscriptions are idle",
+	})
+	kraken_websocket_frames_total = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kraken_websocket_frames_total",
+		Help: "The total number of decoded websocket frames by message kind",
+	}, []string{"kind"})
+	kraken_trades_parsed_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kraken_trades_parsed_total",
+		Help: "The total number of trades parsed from the websocket",
+	})
+	kraken_pairs_stale = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kraken_pairs_stale",
+		Help: "The number of pairs without events for longer than their staleness threshold",
+	})
+	kraken_stale_resubscriptions_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kraken_stale_resubscriptions_total",
+		Help: "The total number of stale pairs resubscribed",
+	})
+	kraken_stale_recycles_total = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kraken_stale_recycles_total",
+		Help: "The total number of connections recycled because all pairs went stale",
+	})
+)
+
+// Time of the last trade of every pair, exported as the age at scrape time
+var lastTrades = newTradeAges()
+
+// Collects the age of the last trade per pair
+type tradeAges struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	desc *prometheus.Desc
+}
+
+func newTradeAges() *tradeAges {
+	ages := &tradeAges{
+		last: make(map[string]time.Time),
+		desc: prometheus.NewDesc(
+			"kraken_pair_last_trade_age_seconds",
+			"The number of seconds since the last trade of the pair was received",
+			[]string{"pair"}, nil,
+		),
+	}
+
+	prometheus.MustRegister(ages)
+
+	return ages
+}
+
+// Observe records a trade of the pair
+func (a *tradeAges) Observe(pair string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last[pair] = time.Now()
+}
+
+// Remove stops exporting the pair
+func (a *tradeAges) Remove(pair string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.last, pair)
+}
+
+func (a *tradeAges) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+func (a *tradeAges) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for pair, last := range a.last {
+		ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, time.Since(last).Seconds(), pair)
+	}
+}
+
+// serveMetrics exposes the prometheus metrics on the listen address
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", collector.DefaultHealth.ServeLive)
+	mux.HandleFunc("/readyz", collector.DefaultHealth.ServeReady)
+
+	log.Println("Serving metrics at:", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Println("Metrics endpoint:", err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"collector"
+)
+
+var refreshInterval = flag.Duration("refresh", 10*time.Minute, "interval of pair refreshes from AssetPairs, 0 disables refreshing")
+
+var errNotConnected = errors.New("websocket not connected")
+var errSocketClosed = errors.New("websocket closed")
+
+// Subscribed pairs by their websocket name with the time of their last event, safe for concurrent use
+type Pairs struct {
+	mu         sync.RWMutex
+	pairs      map[string]Pair
+	lastEvents map[string]time.Time
+}
+
+func NewPairs(pairs []Pair) *Pairs {
+	p := &Pairs{
+		pairs:      make(map[string]Pair),
+		lastEvents: make(map[string]time.Time),
+	}
+	p.Update(pairs)
+	return p
+}
+
+// Wsnames returns the websocket names of all pairs
+func (p *Pairs) Wsnames() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	wsnames := make([]string, 0, len(p.pairs))
+	for wsname := range p.pairs {
+		wsnames = append(wsnames, wsname)
+	}
+
+	sort.Strings(wsnames)
+
+	return wsnames
+}
+
+// Get returns the pair with the websocket name
+func (p *Pairs) Get(wsname string) Pair {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pairs[wsname]
+}
+
+// Update replaces the pairs and returns the websocket names of the added and removed ones
+func (p *Pairs) Update(pairs []Pair) ([]string, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := make(map[string]Pair)
+	added := make([]string, 0)
+	removed := make([]string, 0)
+
+	for _, pair := range pairs {
+		next[pair.Wsname] = pair
+		if _, ok := p.pairs[pair.Wsname]; !ok {
+			added = append(added, pair.Wsname)
+			p.lastEvents[pair.Wsname] = time.Now()
+		}
+	}
+
+	for wsname := range p.pairs {
+		if _, ok := next[wsname]; !ok {
+			removed = append(removed, wsname)
+			delete(p.lastEvents, wsname)
+		}
+	}
+
+	p.pairs = next
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// Touch records an event of the pair
+func (p *Pairs) Touch(wsname string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.lastEvents[wsname]; ok {
+		p.lastEvents[wsname] = time.Now()
+	}
+}
+
+// Refresh restarts the staleness period of the pairs
+func (p *Pairs) Refresh(wsnames []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, wsname := range wsnames {
+		if _, ok := p.lastEvents[wsname]; ok {
+			p.lastEvents[wsname] = now
+		}
+	}
+}
+
+// Stale returns the pairs without events for longer than their tier threshold
+func (p *Pairs) Stale(tiers collector.StalenessTiers) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return tiers.Stale(p.lastEvents, func(wsname string) string {
+		return wsname
+	})
+}
+
+// Websocket connection shared between the read loop and the pair refresh.
+// Gorilla websocket supports only one concurrent writer, so all writes go through the lock.
+type KrakenSocket struct {
+	mu        sync.Mutex
+	ws        *websocket.Conn
+	keepalive *collector.Keepalive
+	closed    bool
+	done      chan struct{}
+	pings     int
+}
+
+// WriteJSON writes the message to the current connection
+func (s *KrakenSocket) WriteJSON(v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ws == nil {
+		return errNotConnected
+	}
+
+	return s.ws.WriteJSON(v)
+}
+
+// Ping sends the ping event, Kraken answers with a pong event
+func (s *KrakenSocket) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ws == nil {
+		return errNotConnected
+	}
+
+	s.pings++
+	return s.ws.WriteJSON(&Message{Event: "ping", ReqID: s.pings})
+}
+
+// Attach subscribes the pairs on the new connection and makes it available for writing
+func (s *KrakenSocket) Attach(ws *websocket.Conn, keepalive *collector.Keepalive, pairs *Pairs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errSocketClosed
+	}
+
+	if err := subscribePairs(ws, "subscribe", pairs.Wsnames()); err != nil {
+		return err
+	}
+
+	s.ws = ws
+	s.keepalive = keepalive
+
+	return nil
+}
+
+// Resubscribe renews the subscriptions of the pairs on the live connection
+func (s *KrakenSocket) Resubscribe(wsnames []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ws == nil {
+		return errNotConnected
+	}
+
+	if err := subscribePairs(s.ws, "unsubscribe", wsnames); err != nil {
+		return err
+	}
+
+	return subscribePairs(s.ws, "subscribe", wsnames)
+}
+
+// Recycle closes the live connection, the read loop reconnects with all pairs
+func (s *KrakenSocket) Recycle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ws != nil {
+		s.ws.Close()
+	}
+}
+
+// Close performs the closing handshake on the live connection and stops reconnecting
+func (s *KrakenSocket) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+
+	if s.done != nil {
+		close(s.done)
+	}
+
+	if s.keepalive != nil {
+		s.keepalive.Close()
+	}
+}
+
+// Closed reports whether the socket was closed for good
+func (s *KrakenSocket) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Done returns a channel closed once the socket is closed for good
+func (s *KrakenSocket) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done == nil {
+		s.done = make(chan struct{})
+		if s.closed {
+			close(s.done)
+		}
+	}
+
+	return s.done
+}
+
+// Check fails while there is no live connection
+func (s *KrakenSocket) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ws == nil {
+		return errNotConnected
+	}
+	return nil
+}
+
+// Detach stops the writes to the closed connection
+func (s *KrakenSocket) Detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keepalive != nil {
+		s.keepalive.Stop()
+	}
+
+	s.ws = nil
+	s.keepalive = nil
+}
+
+func subscribePairs(ws *websocket.Conn, event string, wsnames []string) error {
+	if len(wsnames) == 0 {
+		return nil
+	}
+
+	log.Printf("Sending %v for trade events of %v pairs.", event, len(wsnames))
+	err := ws.WriteJSON(&Message{
+		Event: event,
+		Pair:  wsnames,
+		Subscription: map[string]interface{}{
+			"name": "trade",
+		},
+	})
+
+	if err == nil && *bookDepth > 0 {
+		log.Printf("Sending %v for book events of %v pairs.", event, len(wsnames))
+		err = ws.WriteJSON(bookSubscription(event, wsnames))
+	}
+
+	return err
+}
+
+// refreshPairs periodically reloads the asset pairs and changes the subscriptions of the live connection
+func refreshPairs(interval time.Duration, filter *PairFilter, pairs *Pairs, socket *KrakenSocket, books *OrderBooks) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		next, err := requestPairs(filter)
+		if err != nil {
+			log.Println("Could not refresh pairs:", err)
+			continue
+		}
+
+		// Rather keep the subscriptions than unsubscribe every pair on a bogus response
+		if len(next) == 0 {
+			log.Println("Could not refresh pairs: no pairs left after filtering")
+			continue
+		}
+
+		// Hold the socket so a reconnect in the meantime subscribes to the updated pairs
+		socket.mu.Lock()
+
+		added, removed := pairs.Update(next)
+
+		if len(added) > 0 || len(removed) > 0 {
+			log.Println("Pairs added:", added, "removed:", removed)
+		}
+
+		if socket.ws != nil {
+			err = subscribePairs(socket.ws, "unsubscribe", removed)
+			if err == nil {
+				err = subscribePairs(socket.ws, "subscribe", added)
+			}
+			if err != nil {
+				// The read loop reconnects and subscribes to the current pairs
+				log.Println("Could not update subscriptions:", err)
+			}
+		}
+
+		socket.mu.Unlock()
+
+		for _, wsname := range removed {
+			books.Reset(wsname)
+			lastTrades.Remove(wsname)
+		}
+	}
+}
+
+// watchStaleness periodically resubscribes the stale pairs, the connection is recycled when all pairs are stale
+func watchStaleness(tiers collector.StalenessTiers, pairs *Pairs, socket *KrakenSocket) {
+	ticker := time.NewTicker(collector.STALENESS_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		stale := pairs.Stale(tiers)
+		kraken_pairs_stale.Set(float64(len(stale)))
+
+		switch {
+		case len(stale) == 0:
+			continue
+		case len(stale) == len(pairs.Wsnames()):
+			log.Printf("All %v pairs are stale, reconnecting.", len(stale))
+			kraken_stale_recycles_total.Inc()
+			socket.Recycle()
+		default:
+			log.Println("Resubscribing stale pairs:", stale)
+			kraken_stale_resubscriptions_total.Add(float64(len(stale)))
+			if err := socket.Resubscribe(stale); err != nil {
+				log.Println("Could not resubscribe stale pairs:", err)
+				continue
+			}
+			pairs.Refresh(stale)
+		}
+	}
+}